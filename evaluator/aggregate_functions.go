@@ -0,0 +1,248 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Aggregates flatten multi valued arguments, skip blank cells and follow sum() coercion rules:
+// ints, floats and numeric strings are numbers, anything else is an error.
+
+// flattens multi and spread values (recursively) into a single list of values
+func flattenValues(args []CalculatedValue) []CalculatedValue {
+	res := make([]CalculatedValue, 0, len(args))
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case multiValue:
+			res = append(res, flattenValues(v)...)
+		case spreadValue:
+			res = append(res, flattenValues(v)...)
+		default:
+			res = append(res, arg)
+		}
+	}
+	return res
+}
+
+func isBlank(v CalculatedValue) bool {
+	s, ok := v.(stringValue)
+	return ok && s == ""
+}
+
+// converts value to a number, ok is false if value can't be treated as one
+func asNumber(v CalculatedValue) (res float64, ok bool) {
+	switch v := v.(type) {
+	case intValue:
+		return float64(v), true
+	case floatValue:
+		return float64(v), true
+	case stringValue:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return 0, false
+		}
+		return f, true
+	default:
+		return 0, false
+	}
+}
+
+// collects numeric arguments of function fname, first error value found is returned as failure
+func numericArgs(fname string, args []CalculatedValue) (nums []float64, failure errorValue) {
+	nums = make([]float64, 0, len(args))
+	for _, arg := range flattenValues(args) {
+		if e, ok := arg.(errorValue); ok {
+			return nil, e
+		}
+		if isBlank(arg) {
+			continue
+		}
+		f, ok := asNumber(arg)
+		if !ok {
+			switch arg.(type) {
+			case stringValue:
+				panic(fmt.Sprintf("Couldn't convert %s() argument to a number", fname))
+			default:
+				panic(fmt.Sprintf("Unknown argument type passed to %s()", fname))
+			}
+		}
+		nums = append(nums, f)
+	}
+	return nums, ""
+}
+
+func avg(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := numericArgs("avg", args)
+	if failure != "" {
+		return failure
+	}
+	if len(nums) == 0 {
+		return errDivByZero
+	}
+	return floatValue(mean(nums))
+}
+
+func mean(nums []float64) float64 {
+	total := 0.0
+	for _, n := range nums {
+		total += n
+	}
+	return total / float64(len(nums))
+}
+
+// min of no values is 0, like in other spreadsheets
+func minOf(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := numericArgs("min", args)
+	if failure != "" {
+		return failure
+	}
+	if len(nums) == 0 {
+		return floatValue(0)
+	}
+	res := nums[0]
+	for _, n := range nums[1:] {
+		res = math.Min(res, n)
+	}
+	return floatValue(res)
+}
+
+// max of no values is 0, like in other spreadsheets
+func maxOf(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := numericArgs("max", args)
+	if failure != "" {
+		return failure
+	}
+	if len(nums) == 0 {
+		return floatValue(0)
+	}
+	res := nums[0]
+	for _, n := range nums[1:] {
+		res = math.Max(res, n)
+	}
+	return floatValue(res)
+}
+
+// counts arguments that can be treated as numbers
+func count(call *Call, args []CalculatedValue) CalculatedValue {
+	res := 0
+	for _, arg := range flattenValues(args) {
+		if _, ok := asNumber(arg); ok {
+			res++
+		}
+	}
+	return intValue(res)
+}
+
+// counts non-blank arguments
+func counta(call *Call, args []CalculatedValue) CalculatedValue {
+	res := 0
+	for _, arg := range flattenValues(args) {
+		if !isBlank(arg) {
+			res++
+		}
+	}
+	return intValue(res)
+}
+
+func countblank(call *Call, args []CalculatedValue) CalculatedValue {
+	res := 0
+	for _, arg := range flattenValues(args) {
+		if isBlank(arg) {
+			res++
+		}
+	}
+	return intValue(res)
+}
+
+// product of no values is 0, like in other spreadsheets
+func product(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := numericArgs("product", args)
+	if failure != "" {
+		return failure
+	}
+	if len(nums) == 0 {
+		return floatValue(0)
+	}
+	res := 1.0
+	for _, n := range nums {
+		res *= n
+	}
+	return floatValue(res)
+}
+
+func median(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := numericArgs("median", args)
+	if failure != "" {
+		return failure
+	}
+	if len(nums) == 0 {
+		return errNum
+	}
+	sort.Float64s(nums)
+	mid := len(nums) / 2
+	if len(nums)%2 == 1 {
+		return floatValue(nums[mid])
+	}
+	return floatValue((nums[mid-1] + nums[mid]) / 2)
+}
+
+// most frequent value, ties are resolved by first occurrence; #N/A when no value repeats
+func mode(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := numericArgs("mode", args)
+	if failure != "" {
+		return failure
+	}
+	counts := make(map[float64]int)
+	for _, n := range nums {
+		counts[n]++
+	}
+	best, bestCount := 0.0, 1
+	for _, n := range nums {
+		if counts[n] > bestCount {
+			best, bestCount = n, counts[n]
+		}
+	}
+	if bestCount == 1 {
+		return errNotAvailable
+	}
+	return floatValue(best)
+}
+
+// sample variance, needs at least two values
+func sampleVariance(nums []float64) (float64, bool) {
+	if len(nums) < 2 {
+		return 0, false
+	}
+	m := mean(nums)
+	sq := 0.0
+	for _, n := range nums {
+		sq += (n - m) * (n - m)
+	}
+	return sq / float64(len(nums)-1), true
+}
+
+func variance(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := numericArgs("var", args)
+	if failure != "" {
+		return failure
+	}
+	res, ok := sampleVariance(nums)
+	if !ok {
+		return errDivByZero
+	}
+	return floatValue(res)
+}
+
+func stdev(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := numericArgs("stdev", args)
+	if failure != "" {
+		return failure
+	}
+	res, ok := sampleVariance(nums)
+	if !ok {
+		return errDivByZero
+	}
+	return floatValue(math.Sqrt(res))
+}
@@ -0,0 +1,208 @@
+package evaluator
+
+import (
+	"sort"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// Array functions work on multi values: array literals, ranges and results of functions like split().
+// A two dimensional array is a multi value of rows, positions are 1-based and negative ones count
+// from the end, so at(values, -1) is the last element.
+
+// {1, 2, 3} is a flat multi value, {1, 2; 3, 4} a multi value of rows
+func calcArrayLit(es *evalState, v m.ArrayLit, rowIdx, colIdx int) CalculatedValue {
+	rows := make(multiValue, len(v.Rows))
+	for i, row := range v.Rows {
+		values := make(multiValue, len(row))
+		for j := range row {
+			values[j] = calcExpr(es, &row[j], rowIdx, colIdx)
+		}
+		rows[i] = values
+	}
+	if len(rows) == 1 {
+		return rows[0]
+	}
+	return rows
+}
+
+// converts 1-based, possibly negative position to a 0-based index, ok is false when out of range
+func arrayIndex(pos, size int) (int, bool) {
+	if pos < 0 {
+		pos += size + 1
+	}
+	if pos < 1 || pos > size {
+		return 0, false
+	}
+	return pos - 1, true
+}
+
+// at(array, index, [column]) is the element (or row of a 2D array) at 1-based index
+func at(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	var res CalculatedValue = args[0]
+	for _, arg := range args[1:] {
+		mv, ok := res.(multiValue)
+		if !ok {
+			return errRef
+		}
+		idx, ok := arrayIndex(asInt("at", arg), len(mv))
+		if !ok {
+			return errRef
+		}
+		res = mv[idx]
+	}
+	return res
+}
+
+// slice(array, start, [count]) takes count elements from 1-based start, or all remaining ones
+func slice(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	mv := args[0].(multiValue)
+	start := asInt("slice", args[1])
+	if start < 0 {
+		start += len(mv) + 1
+	}
+	from := clamp(start-1, 0, len(mv))
+	to := len(mv)
+	if len(args) > 2 {
+		count := asInt("slice", args[2])
+		if count < 0 {
+			return errValue
+		}
+		to = clamp(from+count, from, len(mv))
+	}
+	return append(multiValue{}, mv[from:to]...)
+}
+
+func clamp(x, low, high int) int {
+	if x < low {
+		return low
+	}
+	if x > high {
+		return high
+	}
+	return x
+}
+
+// rows of 2D arrays are sorted by their first element
+func sortKey(v CalculatedValue) CalculatedValue {
+	if row, ok := v.(multiValue); ok && len(row) > 0 {
+		return row[0]
+	}
+	return v
+}
+
+// sort(array, [descending=false]) orders elements like lookups compare them, the sort is stable
+func sortValues(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	descending := len(args) > 1 && truthy("sort", args[1])
+	res := append(multiValue{}, args[0].(multiValue)...)
+	sort.SliceStable(res, func(i, j int) bool {
+		cmp := compareValues(sortKey(res[i]), sortKey(res[j]))
+		if descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	return res
+}
+
+func equalValues(a, b CalculatedValue) bool {
+	am, aIsMulti := a.(multiValue)
+	bm, bIsMulti := b.(multiValue)
+	if aIsMulti || bIsMulti {
+		if !aIsMulti || !bIsMulti || len(am) != len(bm) {
+			return false
+		}
+		for i := range am {
+			if !equalValues(am[i], bm[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return compareValues(a, b) == 0
+}
+
+// unique(array) drops repeated elements (or rows), keeping the first occurrence
+func unique(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	res := make(multiValue, 0)
+	for _, v := range args[0].(multiValue) {
+		seen := false
+		for _, u := range res {
+			if equalValues(u, v) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// reverse(array) puts elements (or rows) in reverse order
+func reverse(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	mv := args[0].(multiValue)
+	res := make(multiValue, len(mv))
+	for i, v := range mv {
+		res[len(mv)-1-i] = v
+	}
+	return res
+}
+
+// flatten(values...) puts all elements of arrays into a single flat array
+func flatten(call *Call, args []CalculatedValue) CalculatedValue {
+	return multiValue(flattenValues(args))
+}
+
+// sequence(rows, [columns=1], [start=1], [step=1]) is an array of numbers, flat for a single row
+func sequence(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	rows := asInt("sequence", args[0])
+	cols := optionalInt("sequence", args, 1, 1)
+	if rows < 1 || cols < 1 {
+		return errValue
+	}
+	var start, step CalculatedValue = intValue(1), intValue(1)
+	if len(args) > 2 {
+		start = numericValue("sequence", args[2])
+	}
+	if len(args) > 3 {
+		step = numericValue("sequence", args[3])
+	}
+	if _, ok := step.(floatValue); ok {
+		// all elements of the same type
+		start = calcBinaryOp(m.ADD, start, floatValue(0))
+	}
+	next := start
+	values := make(multiValue, rows*cols)
+	for i := range values {
+		values[i] = next
+		next = calcBinaryOp(m.ADD, next, step)
+	}
+	if rows == 1 {
+		return values
+	}
+	res := make(multiValue, rows)
+	for i := range res {
+		res[i] = values[i*cols : (i+1)*cols]
+	}
+	return res
+}
@@ -0,0 +1,268 @@
+package evaluator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// Diagnostic is a problem found in a formula before evaluation
+type Diagnostic struct {
+	Sheet   string // set for workbooks
+	Row     int    // 0-based
+	Col     int    // 0-based
+	Message string
+	Warning bool // the sheet can still be evaluated
+}
+
+func cellName(rowIdx, colIdx int) string {
+	return fmt.Sprintf("%c%d", 'A'+colIdx, rowIdx+1)
+}
+
+// cell name prefixed with its sheet, if any, e.g. Prices!A1
+func cellPosition(sheet string, rowIdx, colIdx int) string {
+	if sheet == "" {
+		return cellName(rowIdx, colIdx)
+	}
+	return fmt.Sprintf("%s!%s", sheet, cellName(rowIdx, colIdx))
+}
+
+func (d Diagnostic) String() string {
+	if d.Warning {
+		return fmt.Sprintf("%s: warning: %s", cellPosition(d.Sheet, d.Row, d.Col), d.Message)
+	}
+	return fmt.Sprintf("%s: %s", cellPosition(d.Sheet, d.Row, d.Col), d.Message)
+}
+
+// label names that formulas can refer to, mirrors labelName of the parser without the dot,
+// which separates the section from the label
+var labelNamePattern = regexp.MustCompile(`^[\p{L}_][\p{L}\p{Nd}_]*$`)
+
+// label referenced by expression, if any
+func referencedLabel(expr m.Expr) (string, bool) {
+	switch v := expr.(type) {
+	case m.LabelRelativeRowRef:
+		return v.Label, true
+	case m.LabelBlock:
+		return v.Label, true
+	case m.LabelColumnRef:
+		return v.Label, true
+	default:
+		return "", false
+	}
+}
+
+// reports labels that formulas can't refer to and labels hidden by a later definition
+func checkLabels(cells CSVCells) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0)
+	// cell and qualified name (if any) of the latest definition of a label
+	definedAt := make(map[string]string)
+	qualifiedAs := make(map[string]string)
+	for rowIdx, row := range cells {
+		seen := make(map[string]bool)
+		section := ""
+		for colIdx, cell := range row {
+			label, ok := cell.(m.LabelCell)
+			if !ok {
+				continue
+			}
+			if section == "" {
+				section = label.Label
+			}
+			qualified := ""
+			if !strings.Contains(section, ".") {
+				qualified = section + "." + label.Label
+			}
+			var problem string
+			switch {
+			case strings.Contains(label.Label, "."):
+				problem = fmt.Sprintf("label %q cannot be referenced, dots separate the section from the label", label.Label)
+			case !labelNamePattern.MatchString(label.Label):
+				problem = fmt.Sprintf("label %q cannot be referenced, use letters, digits and _", label.Label)
+			case seen[label.Label]:
+				problem = fmt.Sprintf("label %s is repeated in the row, only the last one can be referenced", label.Label)
+			case definedAt[label.Label] == "":
+			case qualifiedAs[label.Label] == "" || qualifiedAs[label.Label] == qualified:
+				problem = fmt.Sprintf("label %s hides the one in %s, which can't be referenced any more", label.Label, definedAt[label.Label])
+			default:
+				problem = fmt.Sprintf("label %s hides the one in %s, refer to it as @%s", label.Label, definedAt[label.Label], qualifiedAs[label.Label])
+			}
+			seen[label.Label] = true
+			if !strings.Contains(label.Label, ".") {
+				definedAt[label.Label] = cellName(rowIdx, colIdx)
+				qualifiedAs[label.Label] = qualified
+			}
+			if problem != "" {
+				diagnostics = append(diagnostics, Diagnostic{
+					Row:     rowIdx,
+					Col:     colIdx,
+					Message: problem,
+					Warning: true,
+				})
+			}
+		}
+	}
+	return diagnostics
+}
+
+// value of a literal expression
+func literalValue(expr m.Expr) (CalculatedValue, bool) {
+	switch v := expr.(type) {
+	case m.IntLit:
+		return intValue(v), true
+	case m.FloatLit:
+		return floatValue(v), true
+	case m.StringLit:
+		return stringValue(v), true
+	default:
+		return nil, false
+	}
+}
+
+func isSpreadCall(expr m.Expr) bool {
+	fc, ok := expr.(m.FunCall)
+	return ok && fc.Name == "spread"
+}
+
+// checks a single function call against its spec
+func checkFunCall(registry *FunctionRegistry, fc m.FunCall) []string {
+	spec, ok := registry.Lookup(fc.Name)
+	if !ok {
+		return []string{fmt.Sprintf("unknown function %s()", fc.Name)}
+	}
+	problems := make([]string, 0)
+	// spread() arguments expand to an unknown number of arguments
+	spreadArgs := 0
+	for _, param := range fc.Params {
+		if !spec.Lazy && isSpreadCall(param) {
+			spreadArgs++
+		}
+	}
+	argc := len(fc.Params) - spreadArgs
+	arityOk := spec.AcceptsArgCount(argc)
+	if spreadArgs > 0 {
+		arityOk = spec.MaxArgs == Variadic || argc <= spec.MaxArgs
+	}
+	if !arityOk {
+		problems = append(problems, fmt.Sprintf("function %s() expects %s, got %d", fc.Name, spec.arityString(), len(fc.Params)))
+	} else if spreadArgs == 0 && spec.CheckArgCount != nil {
+		if problem := spec.CheckArgCount(argc); problem != "" {
+			problems = append(problems, fmt.Sprintf("function %s() %s, got %d", fc.Name, problem, argc))
+		}
+	}
+	if spec.Lazy || spreadArgs > 0 {
+		// argument positions are not known
+		return problems
+	}
+	for i, param := range fc.Params {
+		value, ok := literalValue(param)
+		if !ok {
+			continue
+		}
+		if t := spec.ParamType(i); !t.accepts(value) {
+			problems = append(problems, fmt.Sprintf("function %s() argument %d must be of %v type, got %v", fc.Name, i+1, t, param))
+		}
+	}
+	return problems
+}
+
+// checks all function calls, names and labels in a formula, params are the names bound in it;
+// labels are not checked when nil, e.g. for functions defined in the sheet
+func checkFormula(registry *FunctionRegistry, formula m.Expr, params []string, labels labelMap) []string {
+	bound := make(map[string]bool)
+	for _, param := range params {
+		bound[param] = true
+	}
+	problems := checkExpr(registry, formula, bound)
+	if labels == nil {
+		return problems
+	}
+	m.Walk(formula, func(expr m.Expr) bool {
+		if label, ok := referencedLabel(expr); ok {
+			if _, found := labels[label]; !found {
+				problems = append(problems, fmt.Sprintf("unknown label @%s", label))
+			}
+		}
+		return true
+	})
+	return problems
+}
+
+// like bound, with names added
+func withNames(bound map[string]bool, names []string) map[string]bool {
+	res := make(map[string]bool, len(bound)+len(names))
+	for name := range bound {
+		res[name] = true
+	}
+	for _, name := range names {
+		res[name] = true
+	}
+	return res
+}
+
+func checkExpr(registry *FunctionRegistry, expr m.Expr, bound map[string]bool) []string {
+	problems := make([]string, 0)
+	m.Walk(expr, func(expr m.Expr) bool {
+		switch v := expr.(type) {
+		case m.FunCall:
+			// names bound to lambdas are called like functions
+			if !bound[v.Name] {
+				problems = append(problems, checkFunCall(registry, v)...)
+			}
+		case m.LocalName:
+			if !bound[v.Name] {
+				problems = append(problems, fmt.Sprintf("unknown name %s", v.Name))
+			}
+		case m.Let:
+			for i, value := range v.Values {
+				problems = append(problems, checkExpr(registry, value, withNames(bound, v.Names[:i]))...)
+			}
+			problems = append(problems, checkExpr(registry, v.Body, withNames(bound, v.Names))...)
+			return false
+		case m.Lambda:
+			problems = append(problems, checkExpr(registry, v.Body, withNames(bound, v.Params))...)
+			return false
+		}
+		return true
+	})
+	return problems
+}
+
+// Check reports unknown functions, names and labels, wrong argument counts and wrongly typed literal
+// arguments in all formulas of the sheet, including functions defined in it, without evaluating
+// it. Labels that can't be referenced are reported as warnings. Functions are looked up in the
+// registry given with WithFunctions and parameters in the ones given with WithParams, other options
+// are ignored.
+func Check(cells CSVCells, options ...Option) []Diagnostic {
+	es := prepareState(cells, options)
+	return checkSheet(&es)
+}
+
+func checkSheet(es *evalState) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0)
+	for rowIdx, row := range es.csvCells {
+		for colIdx, cell := range row {
+			var problems []string
+			switch v := cell.(type) {
+			case m.FormulaCell:
+				problems = checkFormula(es.functions, v.Formula, nil, es.labelsOnRow[rowIdx])
+				problems = append(problems, checkSheetRefs(es, v.Formula)...)
+				problems = append(problems, checkParamRefs(es, v.Formula)...)
+			case m.FunctionDefCell:
+				problems = checkFormula(es.functions, v.Body, v.Params, nil)
+				problems = append(problems, checkSheetRefs(es, v.Body)...)
+				problems = append(problems, checkParamRefs(es, v.Body)...)
+			}
+			for _, problem := range problems {
+				diagnostics = append(diagnostics, Diagnostic{
+					Row:     rowIdx,
+					Col:     colIdx,
+					Message: problem,
+				})
+			}
+		}
+	}
+	return append(diagnostics, checkLabels(es.csvCells)...)
+}
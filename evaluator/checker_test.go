@@ -0,0 +1,120 @@
+package evaluator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"pasza.org/sr-challenge/parser"
+)
+
+func diagnosticStrings(diagnostics []Diagnostic) []string {
+	res := make([]string, len(diagnostics))
+	for i, d := range diagnostics {
+		res[i] = d.String()
+	}
+	return res
+}
+
+func TestCheck(t *testing.T) {
+	csv, ok, err := parser.ParseCSV(`=split(A2)|=incFrom("a")|=nope(1)
+=sum(spread(split(A1, ",")))|=round(spread(split("1,2", ",")), 1, 2, 3)|=concat(text(bte(1, "x")), "y")
+=split("a", 1)|=round("1.5")|=pad("a", "x")|=incFrom(1.5)|=round(1, 2.0)
+=countifs(A1, 1, A2)|=sumifs(A1, A2, 1)|=maxifs(A1, A2, 1, A3)`)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	diagnostics := Check(csv)
+	assert.Equal(t, []string{
+		"A1: function split() expects exactly 2 argument(s), got 1",
+		`B1: function incFrom() argument 1 must be of int type, got "a"`,
+		"C1: unknown function nope()",
+		"B2: function round() expects 1 to 2 arguments, got 4",
+		`C2: function bte() argument 2 must be of number type, got "x"`,
+		"A3: function split() argument 2 must be of string type, got 1",
+		`C3: function pad() argument 2 must be of int type, got "x"`,
+		"D3: function incFrom() argument 1 must be of int type, got 1.500",
+		"A4: function countifs() expects pairs of range and criteria arguments, got 3",
+		"C4: function maxifs() expects pairs of range and criteria arguments, got 4",
+	}, diagnosticStrings(diagnostics))
+}
+
+func TestCheckCustomFunctions(t *testing.T) {
+	csv, _, err := parser.ParseCSV(`=gasCost(1)`)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"A1: unknown function gasCost()"}, diagnosticStrings(Check(csv)))
+
+	registry := NewFunctionRegistry()
+	registry.Register("gasCost", FunctionSpec{MinArgs: 2, MaxArgs: 2, Fn: sum})
+	assert.Equal(t, []string{"A1: function gasCost() expects exactly 2 argument(s), got 1"},
+		diagnosticStrings(Check(csv, WithFunctions(registry))))
+}
+
+func TestInferTypes(t *testing.T) {
+	csv, _, err := parser.ParseCSV(`!label|12|1.5|text
+=text(bte(B1, C1))|=B1+C1|=B1*2|=D1+B1
+=sum(spread(split(D1, ",")))|="abc" * 2|=upper(split(D1, ","))|=A3+C1
+=@label<1>|=B2+"x"|=split(B1, ",")|=vlookup(1, B1:C1, 2)
+=B4 * 2|=C5|=B6|={1, 2} * C1`)
+	require.Nil(t, err)
+
+	sheetTypes := InferTypes(csv)
+	assert.Equal(t, [][]ValueType{
+		{StringType, IntType, FloatType, StringType},
+		{StringType, FloatType, IntType, StringType},
+		{FloatType, ErrorType, MultiType, FloatType},
+		{StringType, ErrorType, MultiType, UnknownType},
+		{ErrorType, UnknownType, UnknownType, MultiType},
+	}, sheetTypes.Types)
+	assert.Equal(t, []string{
+		`B3: type conflict: string * int in "abc" * 2`,
+		`B4: type conflict: float + string in B2 + "x"`,
+		`C4: type conflict: function split() argument 1 must be of string type, got int from B1`,
+	}, diagnosticStrings(sheetTypes.Diagnostics))
+
+	csv, _, err = parser.ParseCSV(`1|1.5|text
+=-A1|=-B1|=-{1, 2}|=-C1`)
+	require.Nil(t, err)
+	sheetTypes = InferTypes(csv)
+	assert.Equal(t, []ValueType{IntType, FloatType, MultiType, ErrorType}, sheetTypes.Types[1])
+	assert.Equal(t, []string{`D2: type conflict: -string in -C1`}, diagnosticStrings(sheetTypes.Diagnostics))
+}
+
+func TestCheckSheetFunctions(t *testing.T) {
+	csv, _, err := parser.ParseCSV("!def|withFee(x) = x + y|\n=withFee(1)|=withFee(1, 2)|=x\n")
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"B1: unknown name y",
+		"B2: function withFee() expects exactly 1 argument(s), got 2",
+		"C2: unknown name x",
+	}, diagnosticStrings(Check(csv)))
+}
+
+func TestCheckLetAndLambda(t *testing.T) {
+	csv, _, err := parser.ParseCSV(`=let(x, 1, f, lambda(y, x + y), f(x))|=let(x, y, y, 1, x)|=map(split("1", ","), lambda(x, x + z))|=let(x, 1, g(x))`)
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"B1: unknown name y",
+		"C1: unknown name z",
+		"D1: unknown function g()",
+	}, diagnosticStrings(Check(csv)))
+}
+
+func TestCheckLabels(t *testing.T) {
+	csv, _, err := parser.ParseCSV(`!fees|!rate
+0.1|=@fees.rate<1> + @missing<1>
+!taxes|!rate|!total cost|!rate
+=sum(@taxes[*])|=@total[@]
+!fees|!a.b
+`)
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"B2: unknown label @missing",
+		"B4: unknown label @total",
+		"B3: warning: label rate hides the one in B1, refer to it as @fees.rate",
+		`C3: warning: label "total cost" cannot be referenced, use letters, digits and _`,
+		"D3: warning: label rate is repeated in the row, only the last one can be referenced",
+		"A5: warning: label fees hides the one in A1, which can't be referenced any more",
+		`B5: warning: label "a.b" cannot be referenced, dots separate the section from the label`,
+	}, diagnosticStrings(Check(csv)))
+}
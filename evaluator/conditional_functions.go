@@ -0,0 +1,283 @@
+package evaluator
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Conditional aggregates select values using criteria like ">1000", "<>eth" or "e*".
+// Every range argument is used as a list of values: a single row or column as is, and a
+// table with several columns through its first column, so for label blocks it's the column
+// under the label, e.g. sumif(@token, "eth", @amount).
+
+type criterion struct {
+	op      string // one of = <> < <= > >=
+	number  float64
+	text    string
+	numeric bool
+	pattern *regexp.Regexp // for text (in)equality with wildcards
+}
+
+var criterionOps = []string{"<>", "<=", ">=", "<", ">", "="}
+
+// parses criteria such as ">1000", "eth", "t_*" or a plain number
+func parseCriterion(v CalculatedValue) criterion {
+	switch v := v.(type) {
+	case intValue:
+		return criterion{op: "=", number: float64(v), numeric: true}
+	case floatValue:
+		return criterion{op: "=", number: float64(v), numeric: true}
+	}
+	s := v.String()
+	c := criterion{op: "="}
+	for _, op := range criterionOps {
+		if strings.HasPrefix(s, op) {
+			c.op = op
+			s = s[len(op):]
+			break
+		}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		c.number, c.numeric = f, true
+		return c
+	}
+	c.text = s
+	if strings.ContainsAny(s, "*?") {
+		c.pattern = wildcardPattern(s)
+	}
+	return c
+}
+
+// translates wildcards (* any text, ? single character, ~ escapes) to a case-insensitive regexp
+func wildcardPattern(s string) *regexp.Regexp {
+	var buff strings.Builder
+	buff.WriteString("(?is)^")
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			buff.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '~':
+			escaped = true
+		case r == '*':
+			buff.WriteString(".*")
+		case r == '?':
+			buff.WriteString(".")
+		default:
+			buff.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buff.WriteString("$")
+	return regexp.MustCompile(buff.String())
+}
+
+func compareWith(op string, cmp int) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "<>":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func (c criterion) matches(v CalculatedValue) bool {
+	if _, ok := v.(errorValue); ok {
+		return false
+	}
+	if c.numeric {
+		switch v.(type) {
+		case intValue, floatValue:
+			f, _ := asNumber(v)
+			return compareWith(c.op, compareValues(floatValue(f), floatValue(c.number)))
+		default:
+			return c.op == "<>"
+		}
+	}
+	if c.pattern != nil && (c.op == "=" || c.op == "<>") {
+		matched := c.pattern.MatchString(v.String())
+		return matched == (c.op == "=")
+	}
+	if c.op == "=" || c.op == "<>" {
+		equal := strings.EqualFold(v.String(), c.text)
+		if _, isText := v.(stringValue); !isText && c.text != "" {
+			equal = false
+		}
+		return equal == (c.op == "=")
+	}
+	if _, isText := v.(stringValue); !isText {
+		return false
+	}
+	return compareWith(c.op, compareValues(v, stringValue(c.text)))
+}
+
+// interprets range argument as a list of values, see the top of this file
+func asVector(v CalculatedValue) []CalculatedValue {
+	table := asTable(v)
+	if len(table) == 1 {
+		return table[0]
+	}
+	return tableColumn(table, 0)
+}
+
+// returns indexes of values matching all (range, criteria) pairs, ok is false if ranges differ in
+// size or a pair is incomplete, e.g. with a spread() argument
+func matchingIndexes(pairs []CalculatedValue) (idxs []int, ok bool) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, false
+	}
+	size := -1
+	selected := make([]bool, 0)
+	for i := 0; i < len(pairs); i += 2 {
+		values := asVector(pairs[i])
+		c := parseCriterion(pairs[i+1])
+		if size < 0 {
+			size = len(values)
+			for range values {
+				selected = append(selected, true)
+			}
+		}
+		if len(values) != size {
+			return nil, false
+		}
+		for j, v := range values {
+			selected[j] = selected[j] && c.matches(v)
+		}
+	}
+	for j, sel := range selected {
+		if sel {
+			idxs = append(idxs, j)
+		}
+	}
+	return idxs, true
+}
+
+// numbers among values at given indexes, text and blanks are skipped
+func selectedNumbers(values []CalculatedValue, idxs []int) (nums []float64, failure errorValue) {
+	for _, idx := range idxs {
+		if idx >= len(values) {
+			continue
+		}
+		switch v := values[idx].(type) {
+		case errorValue:
+			return nil, v
+		case intValue, floatValue:
+			f, _ := asNumber(v)
+			nums = append(nums, f)
+		}
+	}
+	return nums, ""
+}
+
+// common part of sumif/averageif: (range, criteria, [valueRange])
+func conditionalNumbers(fname string, args []CalculatedValue) ([]float64, errorValue) {
+	idxs, ok := matchingIndexes(args[:2])
+	if !ok {
+		return nil, errValue
+	}
+	values := asVector(args[0])
+	if len(args) > 2 {
+		values = asVector(args[2])
+	}
+	return selectedNumbers(values, idxs)
+}
+
+// common part of sumifs/maxifs/minifs: (valueRange, range1, criteria1, ...)
+func multiConditionalNumbers(fname string, args []CalculatedValue) ([]float64, errorValue) {
+	if len(args) < 3 {
+		panic("Function " + fname + "() expects at least three arguments")
+	}
+	idxs, ok := matchingIndexes(args[1:])
+	values := asVector(args[0])
+	if !ok || len(values) != len(asVector(args[1])) {
+		return nil, errValue
+	}
+	return selectedNumbers(values, idxs)
+}
+
+func total(nums []float64) float64 {
+	res := 0.0
+	for _, n := range nums {
+		res += n
+	}
+	return res
+}
+
+// sumif(range, criteria, [sumRange])
+func sumif(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := conditionalNumbers("sumif", args)
+	if failure != "" {
+		return failure
+	}
+	return floatValue(total(nums))
+}
+
+// averageif(range, criteria, [averageRange])
+func averageif(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := conditionalNumbers("averageif", args)
+	if failure != "" {
+		return failure
+	}
+	if len(nums) == 0 {
+		return errDivByZero
+	}
+	return floatValue(mean(nums))
+}
+
+// countif(range, criteria)
+func countif(call *Call, args []CalculatedValue) CalculatedValue {
+	return countifs(call, args)
+}
+
+// countifs(range1, criteria1, range2, criteria2, ...)
+func countifs(call *Call, args []CalculatedValue) CalculatedValue {
+	idxs, ok := matchingIndexes(args)
+	if !ok {
+		return errValue
+	}
+	return intValue(len(idxs))
+}
+
+// sumifs(sumRange, range1, criteria1, ...)
+func sumifs(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := multiConditionalNumbers("sumifs", args)
+	if failure != "" {
+		return failure
+	}
+	return floatValue(total(nums))
+}
+
+// maxifs(maxRange, range1, criteria1, ...), 0 when nothing matches
+func maxifs(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := multiConditionalNumbers("maxifs", args)
+	if failure != "" {
+		return failure
+	}
+	values := make([]CalculatedValue, len(nums))
+	for i, n := range nums {
+		values[i] = floatValue(n)
+	}
+	return maxOf(call, values)
+}
+
+// minifs(minRange, range1, criteria1, ...), 0 when nothing matches
+func minifs(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := multiConditionalNumbers("minifs", args)
+	if failure != "" {
+		return failure
+	}
+	values := make([]CalculatedValue, len(nums))
+	for i, n := range nums {
+		values[i] = floatValue(n)
+	}
+	return minOf(call, values)
+}
@@ -3,7 +3,9 @@ package evaluator
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
 	"strconv"
+	"strings"
 
 	m "pasza.org/sr-challenge/model"
 )
@@ -20,6 +22,19 @@ type boolValue bool
 type multiValue []CalculatedValue
 type spreadValue []CalculatedValue
 
+// spreadsheet-style error, e.g. #DIV/0!
+type errorValue string
+
+const (
+	errDivByZero    errorValue = "#DIV/0!"
+	errNum          errorValue = "#NUM!"
+	errNotAvailable errorValue = "#N/A"
+	errValue        errorValue = "#VALUE!"
+	errRef          errorValue = "#REF!"
+	errSpill        errorValue = "#SPILL!"
+	errCycle        errorValue = "#CYCLE!"
+)
+
 func (intValue) isCalculatedValue() {}
 func (v intValue) String() string {
 	return strconv.Itoa(int(v))
@@ -44,6 +59,11 @@ func (v boolValue) String() string {
 	}
 }
 
+func (errorValue) isCalculatedValue() {}
+func (v errorValue) String() string {
+	return string(v)
+}
+
 func multipleValueStringer(v []CalculatedValue) string {
 	var buff bytes.Buffer
 	buff.WriteRune('[')
@@ -68,10 +88,12 @@ func (v spreadValue) String() string {
 }
 
 type evalCell struct {
-	done      bool
-	copyCount int
-	formula   *m.Expr
-	value     CalculatedValue
+	done       bool
+	inProgress bool // being calculated, reaching it again means a reference cycle
+	depth      int  // position on the stack of cells being calculated, while in progress
+	copyCount  int
+	formula    *m.Expr
+	value      CalculatedValue
 }
 
 type labelDef struct {
@@ -79,13 +101,28 @@ type labelDef struct {
 	colIdx int
 }
 
-// given label name, which column is it (if any)
+// given label name, which column is it (if any). Labels are also available qualified with
+// the section they are defined in, named by the first label of their row, e.g. fees.rate for
+// !fees|!rate. The dot only separates sections: labels defined with a dot are left out, so
+// a label a.b can't be mistaken for label b of section a.
 type labelMap map[string]labelDef
 
 type evalState struct {
 	evalCells   [][]evalCell
 	csvCells    CSVCells
 	labelsOnRow []labelMap
+	rng         *rand.Rand
+	functions   *FunctionRegistry
+	scope       *scope // names bound in the expression being evaluated
+	callDepth   int
+	noSpill     bool
+	workbook    workbookState // other sheets, nil when evaluating a single sheet
+	params      map[string]CalculatedValue
+
+	formulaCells [][2]int                   // positions of formula cells, in row order
+	spilled      map[[2]int]CalculatedValue // values of cells arrays spilled into, formula cells included
+	spillBlocked map[[2]int]bool            // formula cells with arrays that couldn't spill
+	stack        *calcStack                 // shared by sheets of a workbook
 }
 
 type CSVCells [][]m.Cell
@@ -112,35 +149,61 @@ func initState(cells CSVCells) evalState {
 	currentLabels := make(labelMap)
 	for rowIdx, row := range cells {
 		newLabelMapNeeded := true
+		section := ""
 		width := len(row)
 		evalRow := make([]evalCell, width)
 		for colIdx, cell := range row {
 			if labelCell, ok := cell.(m.LabelCell); ok {
+				label := labelCell.Label
 				if newLabelMapNeeded {
 					currentLabels = copyMap(currentLabels)
 					newLabelMapNeeded = false
+					section = label
 				}
-				label := labelCell.Label
-				currentLabels[label] = labelDef{
-					rowIdx: rowIdx,
-					colIdx: colIdx,
+				if !strings.Contains(label, ".") {
+					def := labelDef{
+						rowIdx: rowIdx,
+						colIdx: colIdx,
+					}
+					if !strings.Contains(section, ".") {
+						currentLabels[section+"."+label] = def
+					}
+					currentLabels[label] = def
 				}
 				finishLabelCell(&evalRow[colIdx], label)
 			}
+			if isCopyOfAbove(cell, colIdx) && rowIdx > 0 && colIdx < len(evalCells[rowIdx-1]) {
+				// counted before evaluation, so incFrom() doesn't depend on the order cells are calculated in
+				evalRow[colIdx].copyCount = evalCells[rowIdx-1][colIdx].copyCount + 1
+			}
 		}
 		evalCells[rowIdx] = evalRow
 		labelsOnRow[rowIdx] = currentLabels
 	}
+	formulaCells := make([][2]int, 0)
+	for rowIdx, row := range cells {
+		for colIdx, cell := range row {
+			if _, ok := cell.(m.FormulaCell); ok {
+				formulaCells = append(formulaCells, [2]int{rowIdx, colIdx})
+			}
+		}
+	}
 	return evalState{
-		evalCells:   evalCells,
-		csvCells:    cells,
-		labelsOnRow: labelsOnRow,
+		evalCells:    evalCells,
+		csvCells:     cells,
+		labelsOnRow:  labelsOnRow,
+		formulaCells: formulaCells,
+		spilled:      make(map[[2]int]CalculatedValue),
+		spillBlocked: make(map[[2]int]bool),
+		stack:        &calcStack{probe: -1},
 	}
 }
 
 func calcCell(es *evalState, rowIdx int, colIdx int) {
 	cell := es.csvCells[rowIdx][colIdx]
 	esCell := &es.evalCells[rowIdx][colIdx]
+	esCell.inProgress = true
+	es.stack.push(esCell)
 	switch v := cell.(type) {
 	case m.IntCell:
 		esCell.value = intValue(v.Value)
@@ -150,16 +213,81 @@ func calcCell(es *evalState, rowIdx int, colIdx int) {
 		esCell.value = stringValue(v.Value)
 	case m.FormulaCell:
 		calcFormulaCell(es, rowIdx, colIdx, &v)
+	case m.FunctionDefCell:
+		esCell.value = stringValue(v.String())
 	default:
 		// label cells should be calculated beforehand
 		panic("Cannot evaluate unknown cell type")
 	}
+	es.stack.pop()
+	esCell.inProgress = false
 	esCell.done = true
+	if table := spillTable(esCell.value); table != nil && !es.noSpill {
+		spill(es, rowIdx, colIdx, table)
+	}
+}
+
+// -x of numbers, element-wise for multi values; other values give #VALUE!
+func negate(v CalculatedValue) CalculatedValue {
+	switch v := v.(type) {
+	case intValue:
+		return -v
+	case floatValue:
+		return -v
+	case errorValue:
+		return v
+	case multiValue:
+		res := make(multiValue, len(v))
+		for i, elem := range v {
+			res[i] = negate(elem)
+		}
+		return res
+	default:
+		return errValue
+	}
 }
 
 func calcInfixOp(es *evalState, v m.InfixOp, rowIdx int, colIdx int) CalculatedValue {
 	lhs, rhs := calcExpr(es, &v.Lhs, rowIdx, colIdx), calcExpr(es, &v.Rhs, rowIdx, colIdx)
-	switch v.Op {
+	return calcBinaryOp(v.Op, lhs, rhs)
+}
+
+// applies op to values, element-wise when any of them is a multi value:
+// a scalar is combined with each element, multi values of different sizes give #VALUE!
+func calcBinaryOp(op m.BinaryOperator, lhs, rhs CalculatedValue) CalculatedValue {
+	// errors propagate through arithmetic
+	if e, ok := lhs.(errorValue); ok {
+		return e
+	}
+	if e, ok := rhs.(errorValue); ok {
+		return e
+	}
+	lm, lhsIsMulti := lhs.(multiValue)
+	rm, rhsIsMulti := rhs.(multiValue)
+	switch {
+	case lhsIsMulti && rhsIsMulti:
+		if len(lm) != len(rm) {
+			return errValue
+		}
+		res := make(multiValue, len(lm))
+		for i := range lm {
+			res[i] = calcBinaryOp(op, lm[i], rm[i])
+		}
+		return res
+	case lhsIsMulti:
+		res := make(multiValue, len(lm))
+		for i := range lm {
+			res[i] = calcBinaryOp(op, lm[i], rhs)
+		}
+		return res
+	case rhsIsMulti:
+		res := make(multiValue, len(rm))
+		for i := range rm {
+			res[i] = calcBinaryOp(op, lhs, rm[i])
+		}
+		return res
+	}
+	switch op {
 	case m.MUL:
 		return calcMul(lhs, rhs)
 	case m.DIV:
@@ -183,6 +311,8 @@ func calcExpr(es *evalState, expr *m.Expr, rowIdx int, colIdx int) CalculatedVal
 		return stringValue(v)
 	case m.InfixOp:
 		return calcInfixOp(es, v, rowIdx, colIdx)
+	case m.Negation:
+		return negate(calcExpr(es, &v.Expr, rowIdx, colIdx))
 	case m.FunCall:
 		return calcFunCall(es, v, rowIdx, colIdx)
 	case m.CellRef:
@@ -195,6 +325,30 @@ func calcExpr(es *evalState, expr *m.Expr, rowIdx int, colIdx int) CalculatedVal
 		return calcCopyLastInColumn(es, v, rowIdx, colIdx)
 	case m.LabelRelativeRowRef:
 		return calcLabelRelativeRowRef(es, v, rowIdx, colIdx)
+	case m.CellRange:
+		return calcCellRange(es, v, rowIdx, colIdx)
+	case m.SpillRef:
+		return calcSpillRef(es, v, rowIdx, colIdx)
+	case m.SheetRef:
+		return calcSheetRef(es, v, rowIdx, colIdx)
+	case m.ParamRef:
+		return calcParamRef(es, v)
+	case m.R1C1Ref:
+		return calcR1C1Ref(es, v, rowIdx, colIdx)
+	case m.R1C1Range:
+		return calcR1C1Range(es, v, rowIdx, colIdx)
+	case m.LabelBlock:
+		return calcLabelBlock(es, v, rowIdx, colIdx)
+	case m.LabelColumnRef:
+		return calcLabelColumnRef(es, v, rowIdx, colIdx)
+	case m.LocalName:
+		return calcLocalName(es, v)
+	case m.ArrayLit:
+		return calcArrayLit(es, v, rowIdx, colIdx)
+	case m.Let:
+		return calcLet(es, v, rowIdx, colIdx)
+	case m.Lambda:
+		return calcLambda(es, v)
 	default:
 		panic("Cannot evaluate unknown expression")
 	}
@@ -210,13 +364,24 @@ func calcLabelRelativeRowRef(es *evalState, v m.LabelRelativeRowRef, rowIdx, col
 	relativeRow := v.RelativeRow
 	labelAnchor, found := es.labelsOnRow[rowIdx][label]
 	if !found {
-		panic("Invalid relative row reference")
+		// unknown label, e.g. mistyped in a sweep output
+		return errRef
 	}
 	targetColIdx := labelAnchor.colIdx
 	targetRowIdx := labelAnchor.rowIdx + relativeRow
+	if v.FromCurrentRow {
+		targetRowIdx = rowIdx + relativeRow
+	}
+	if !isInSheet(es, targetRowIdx, targetColIdx) {
+		return outsideValue(es, targetRowIdx, targetColIdx, errRef)
+	}
 	return getTargetValue(es, targetRowIdx, targetColIdx)
 }
 
+func isInSheet(es *evalState, rowIdx, colIdx int) bool {
+	return rowIdx >= 0 && rowIdx < len(es.evalCells) && colIdx >= 0 && colIdx < len(es.evalCells[rowIdx])
+}
+
 func calcCopyLastInColumn(es *evalState, v m.CopyLastInColumn, rowIdx, colIdx int) CalculatedValue {
 	targetColIdx := colNameToIdx(v.Col)
 	// search upwards for available column
@@ -231,10 +396,20 @@ func calcCopyLastInColumn(es *evalState, v m.CopyLastInColumn, rowIdx, colIdx in
 }
 
 func getTargetValue(es *evalState, targetRowIdx int, targetColIdx int) CalculatedValue {
+	if v, ok := spilledValue(es, targetRowIdx, targetColIdx); ok {
+		return v
+	}
 	target := &es.evalCells[targetRowIdx][targetColIdx]
+	if target.inProgress {
+		return cycle(es, target.depth)
+	}
 	if !target.done {
 		calcCell(es, targetRowIdx, targetColIdx)
 	}
+	if first, ok := es.spilled[[2]int{targetRowIdx, targetColIdx}]; ok {
+		// the array spilled, the cell holds its first element like the sheet shows
+		return first
+	}
 	return target.value
 }
 
@@ -247,21 +422,191 @@ func calcCopyColumnAbove(es *evalState, v m.CopyColumnAbove, rowIdx, colIdx int)
 func calcCellRef(es *evalState, v m.CellRef, rowIdx, colIdx int) CalculatedValue {
 	targetColIdx := colNameToIdx(v.Col)
 	targetRowIdx := v.Row - 1 // we use 0-based indexing
+	if !isInSheet(es, targetRowIdx, targetColIdx) {
+		// e.g. copied above the first row
+		return outsideValue(es, targetRowIdx, targetColIdx, errRef)
+	}
+	return getTargetValue(es, targetRowIdx, targetColIdx)
+}
+
+// e.g. R[-1]C[2], relative to the formula cell
+func calcR1C1Ref(es *evalState, v m.R1C1Ref, rowIdx, colIdx int) CalculatedValue {
+	targetRowIdx, targetColIdx := v.Target(rowIdx, colIdx)
+	if !isInSheet(es, targetRowIdx, targetColIdx) {
+		return outsideValue(es, targetRowIdx, targetColIdx, errRef)
+	}
+	return getTargetValue(es, targetRowIdx, targetColIdx)
+}
 
+// value of a cell inside a range, cells missing from short rows are blank
+func getRangeValue(es *evalState, targetRowIdx int, targetColIdx int) CalculatedValue {
+	if !isInSheet(es, targetRowIdx, targetColIdx) {
+		return outsideValue(es, targetRowIdx, targetColIdx, stringValue(""))
+	}
 	return getTargetValue(es, targetRowIdx, targetColIdx)
 }
 
+// rectangular block of values, as a multi value of rows
+func rangeValue(es *evalState, fromRowIdx, fromColIdx, toRowIdx, toColIdx int) multiValue {
+	if fromRowIdx > toRowIdx {
+		fromRowIdx, toRowIdx = toRowIdx, fromRowIdx
+	}
+	if fromColIdx > toColIdx {
+		fromColIdx, toColIdx = toColIdx, fromColIdx
+	}
+	rows := make(multiValue, 0, toRowIdx-fromRowIdx+1)
+	for r := fromRowIdx; r <= toRowIdx; r++ {
+		row := make(multiValue, 0, toColIdx-fromColIdx+1)
+		for c := fromColIdx; c <= toColIdx; c++ {
+			row = append(row, getRangeValue(es, r, c))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// e.g. A1:C3
+func calcCellRange(es *evalState, v m.CellRange, rowIdx, colIdx int) CalculatedValue {
+	return rangeValue(es,
+		v.From.Row-1, colNameToIdx(v.From.Col),
+		v.To.Row-1, colNameToIdx(v.To.Col),
+	)
+}
+
+func calcR1C1Range(es *evalState, v m.R1C1Range, rowIdx, colIdx int) CalculatedValue {
+	fromRowIdx, fromColIdx := v.From.Target(rowIdx, colIdx)
+	toRowIdx, toColIdx := v.To.Target(rowIdx, colIdx)
+	return rangeValue(es, fromRowIdx, fromColIdx, toRowIdx, toColIdx)
+}
+
+func isLabelCell(es *evalState, rowIdx, colIdx int) bool {
+	if colIdx >= len(es.csvCells[rowIdx]) {
+		return false
+	}
+	_, ok := es.csvCells[rowIdx][colIdx].(m.LabelCell)
+	return ok
+}
+
+func isBlankRow(es *evalState, rowIdx int) bool {
+	for _, cell := range es.csvCells[rowIdx] {
+		if s, ok := cell.(m.StringCell); !ok || s.Value != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func hasLabelCell(es *evalState, rowIdx int) bool {
+	for colIdx := range es.csvCells[rowIdx] {
+		if isLabelCell(es, rowIdx, colIdx) {
+			return true
+		}
+	}
+	return false
+}
+
+// last row of values under a label, rows below it are taken until a blank row or the next row
+// with labels in any column; it's the label row itself if there are no values. Blank cells in the
+// label column don't end the block, so its columns stay aligned, e.g. for sumif() criteria.
+func labelBlockLastRow(es *evalState, labelAnchor labelDef) int {
+	lastRowIdx := labelAnchor.rowIdx
+	for lastRowIdx+1 < len(es.csvCells) &&
+		!isBlankRow(es, lastRowIdx+1) &&
+		!hasLabelCell(es, lastRowIdx+1) {
+		lastRowIdx++
+	}
+	return lastRowIdx
+}
+
+// e.g. @token_prices, the block spans the label column and label columns directly to its right,
+// from the row below the label until a blank row or the next row with labels
+func calcLabelBlock(es *evalState, v m.LabelBlock, rowIdx, colIdx int) CalculatedValue {
+	labelAnchor, found := es.labelsOnRow[rowIdx][v.Label]
+	if !found {
+		return errRef
+	}
+	lastColIdx := labelAnchor.colIdx
+	for isLabelCell(es, labelAnchor.rowIdx, lastColIdx+1) {
+		lastColIdx++
+	}
+	lastRowIdx := labelBlockLastRow(es, labelAnchor)
+	if lastRowIdx == labelAnchor.rowIdx {
+		return multiValue{}
+	}
+	return rangeValue(es, labelAnchor.rowIdx+1, labelAnchor.colIdx, lastRowIdx, lastColIdx)
+}
+
+// e.g. @total_cost[*] or @total_cost[-1], a column of the label block or a value in it,
+// see calcLabelBlock
+func calcLabelColumnRef(es *evalState, v m.LabelColumnRef, rowIdx, colIdx int) CalculatedValue {
+	labelAnchor, found := es.labelsOnRow[rowIdx][v.Label]
+	if !found {
+		return errRef
+	}
+	firstRowIdx := labelAnchor.rowIdx + 1
+	size := labelBlockLastRow(es, labelAnchor) - labelAnchor.rowIdx
+	if !v.Whole && !v.Slice {
+		idx, ok := arrayIndex(v.From, size)
+		if !ok {
+			return errRef
+		}
+		return getRangeValue(es, firstRowIdx+idx, labelAnchor.colIdx)
+	}
+	from, to := 1, size
+	if v.Slice {
+		from, to = v.From, v.To
+		if from < 0 {
+			from += size + 1
+		}
+		if to < 0 {
+			to += size + 1
+		}
+		from, to = clamp(from, 1, size+1), clamp(to, 0, size)
+	}
+	if from > to {
+		return multiValue{}
+	}
+	return rangeValue(es, firstRowIdx+from-1, labelAnchor.colIdx, firstRowIdx+to-1, labelAnchor.colIdx)
+}
+
+// ^^ or a formula continuing its own column with e.g. C^, both count as copies for incFrom()
+func isCopyOfAbove(cell m.Cell, colIdx int) bool {
+	formula, ok := cell.(m.FormulaCell)
+	if !ok {
+		return false
+	}
+	if _, ok := formula.Formula.(m.CopyAbove); ok {
+		return true
+	}
+	found := false
+	m.Walk(formula.Formula, func(expr m.Expr) bool {
+		if v, ok := expr.(m.CopyColumnAbove); ok && colNameToIdx(v.Col) == colIdx {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
 func calcCopyAbove(es *evalState, v m.CopyAbove, rowIdx, colIdx int) CalculatedValue {
 	if rowIdx <= 0 {
 		panic("Attempted to copy above in row 0")
 	}
 	above := &es.evalCells[rowIdx-1][colIdx]
+	if above.inProgress {
+		return cycle(es, above.depth)
+	}
 	if !above.done {
 		calcCell(es, rowIdx-1, colIdx)
 	}
 	ec := &es.evalCells[rowIdx][colIdx]
-	ec.copyCount = above.copyCount + 1
-	ec.formula = above.formula
+	if above.formula != nil {
+		// like fill down, references move with the formula unless anchored
+		shifted := m.ShiftRows(*above.formula, 1)
+		ec.formula = &shifted
+	} else {
+		ec.formula = nil
+	}
 	if ec.formula != nil {
 		ec.value = calcExpr(es, ec.formula, rowIdx, colIdx)
 	} else {
@@ -271,6 +616,11 @@ func calcCopyAbove(es *evalState, v m.CopyAbove, rowIdx, colIdx int) CalculatedV
 }
 
 func calcFormulaCell(es *evalState, rowIdx, colIdx int, cell *m.FormulaCell) {
+	// other cell's formula doesn't see names bound in the current expression, restored also when
+	// an evaluation probing for spilled arrays is abandoned
+	saved := es.scope
+	es.scope = nil
+	defer func() { es.scope = saved }()
 	esCell := &es.evalCells[rowIdx][colIdx]
 	esCell.formula = &cell.Formula
 	esCell.value = calcExpr(es, &cell.Formula, rowIdx, colIdx)
@@ -286,18 +636,32 @@ func calculateAll(es *evalState, cells CSVCells) {
 	}
 }
 
-func Evaluate(cells CSVCells) [][]CalculatedValue {
-	evalState := initState(cells)
-	calculateAll(&evalState, cells)
+// initializes state for evaluation or static checks of the sheet
+func prepareState(cells CSVCells, options []Option) evalState {
+	es := initState(fillColumns(cells))
+	applyOptions(&es, options)
+	registerSheetFunctions(&es)
+	return es
+}
+
+func Evaluate(cells CSVCells, options ...Option) [][]CalculatedValue {
+	evalState := prepareState(cells, options)
+	calculateAll(&evalState, evalState.csvCells)
+	return sheetValues(&evalState)
+}
 
-	// rewrite just calculated values and return
-	res := make([][]CalculatedValue, len(evalState.evalCells))
-	for rowIdx, row := range evalState.evalCells {
+// calculated values of the sheet, with arrays spilled unless disabled
+func sheetValues(es *evalState) [][]CalculatedValue {
+	res := make([][]CalculatedValue, len(es.evalCells))
+	for rowIdx, row := range es.evalCells {
 		resRow := make([]CalculatedValue, len(row))
 		for colIdx, cell := range row {
 			resRow[colIdx] = cell.value
 		}
 		res[rowIdx] = resRow
 	}
-	return res
+	if es.noSpill {
+		return res
+	}
+	return withSpilledArrays(es, res)
 }
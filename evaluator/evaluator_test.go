@@ -0,0 +1,907 @@
+package evaluator
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	m "pasza.org/sr-challenge/model"
+	"pasza.org/sr-challenge/parser"
+)
+
+// parses and evaluates csv input, returns stringified values
+func evalSheet(t *testing.T, in string, options ...Option) [][]string {
+	csv, ok, err := parser.ParseCSV(in)
+	require.Nil(t, err)
+	require.True(t, ok)
+	result := Evaluate(csv, options...)
+	res := make([][]string, len(result))
+	for rowIdx, row := range result {
+		res[rowIdx] = make([]string, len(row))
+		for colIdx, v := range row {
+			res[rowIdx][colIdx] = v.String()
+		}
+	}
+	return res
+}
+
+// evaluates single formula cell and returns its stringified value, arrays are not spilled
+func evalFormula(t *testing.T, formula string) string {
+	return evalSheet(t, formula, WithoutSpill())[0][0]
+}
+
+func TestAggregateFunctions(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{`=avg(1, 2, 3.5, "4")`, "2.625"},
+		{`=avg(spread(split("", ",")))`, "#DIV/0!"},
+		{`=min(3, 1.5, 2)`, "1.500"},
+		{`=max(3, 1.5, 2)`, "3.000"},
+		{`=max(spread(split("", ",")))`, "0.000"},
+		{`=count(1, "2", "x", "")`, "2"},
+		{`=counta(1, "2", "x", "")`, "3"},
+		{`=countblank(1, "", "")`, "2"},
+		{`=product(2, 3, 4)`, "24.000"},
+		{`=median(5, 1, 3)`, "3.000"},
+		{`=median(4, 1, 3, 2)`, "2.500"},
+		{`=median(spread(split("", ",")))`, "#NUM!"},
+		{`=mode(1, 2, 2, 3, 3)`, "2.000"},
+		{`=mode(1, 2, 3)`, "#N/A"},
+		{`=var(2, 4, 4, 4, 5, 5, 7, 9)`, "4.571"},
+		{`=stdev(1, 3)`, "1.414"},
+		{`=stdev(1)`, "#DIV/0!"},
+		{`=sum(split("1,2,3", ","))`, "6.000"},
+		{`=avg(split("1,2,3", ","))`, "2.000"},
+		{`=1/0`, "#DIV/0!"},
+		{`=1.0/0`, "#DIV/0!"},
+		{`=1/0.0 + 1`, "#DIV/0!"},
+		{`=sum(1, 4/0)`, "#DIV/0!"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, evalFormula(t, c.in), c.in)
+	}
+}
+
+// the right operand decides between int and float arithmetic as much as the left one
+func TestMixedOperands(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{`=2 * 1.5`, "3.000"},
+		{`=1.5 * 2`, "3.000"},
+		{`=3 / 1.5`, "2.000"},
+		{`=1 + 0.5`, "1.500"},
+		{`=3 - 0.5`, "2.500"},
+		{`=7 / 2`, "3"},
+		{`="a" + 1.5`, "a1.500"},
+		{`=bte(1, 1.5)`, "true"},
+		{`=bte(2, 1.5)`, "false"},
+		{`=bte("5", 3)`, "false"},
+		{`=bte(1, "3.5")`, "true"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, evalFormula(t, c.in), c.in)
+	}
+
+	// running total of example_input/transactions.csv, E^ is a float added to a float sum
+	res := evalSheet(t, `!token_prices|!total_cost
+38341.88,2643.77,1.0003|=sum(spread(split(A2, ",")))
+304.38,2621.15,1.0001|=B^+sum(spread(split(A3, ",")))
+`)
+	assert.Equal(t, "43913.180", res[2][1])
+}
+
+func TestStringFunctions(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{`=upper("zażółć")`, "ZAŻÓŁĆ"},
+		{`=lower(split("A,B", ","))`, "[a, b]"},
+		{`=trim("  eth ")`, "eth"},
+		{`=len("żółw")`, "4"},
+		{`=left("żółw", 2)`, "żó"},
+		{`=left("eth")`, "e"},
+		{`=right("żółw", 3)`, "ółw"},
+		{`=mid("transaction", 3, 5)`, "ansac"},
+		{`=find("ł", "żółw")`, "3"},
+		{`=find("a", "banana", 3)`, "4"},
+		{`=find("x", "banana")`, "#VALUE!"},
+		{`=replace("abcdef", 2, 3, "XY")`, "aXYef"},
+		{`=substitute("a-b-c", "-", "+")`, "a+b+c"},
+		{`=substitute("a-b-c", "-", "+", 2)`, "a-b+c"},
+		{`=repeat("ab", 3)`, "ababab"},
+		{`=join("; ", "a", spread(split("b,c", ",")), split("d,e", ","))`, "a; b; c; d; e"},
+		{`=startsWith("t_1", "t_")`, "true"},
+		{`=endsWith("t_1", "t_")`, "false"},
+		{`=contains(split("btc,eth", ","), "t")`, "[true, true]"},
+		{`=startsWith("abc", mode(1, 2))`, "#N/A"},
+		{`=endsWith(mode(1, 2), "c")`, "#N/A"},
+		{`=contains("abc", 1 / 0)`, "#DIV/0!"},
+		{`=concat(1 / 0, "a")`, "#DIV/0!"},
+		{`=len(split("ab,c", ","))`, "2"},
+		{`=map(split("ab,c", ","), lambda(s, len(s)))`, "[2, 1]"},
+		{`=pad("7", 3, "0")`, "007"},
+		{`=pad("ż", -3, ".")`, "ż.."},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, evalFormula(t, c.in), c.in)
+	}
+}
+
+func TestMathFunctions(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{`=round(2.345, 2)`, "2.350"},
+		{`=round(1250, -2)`, "1300.000"},
+		{`=roundup(1.21, 1)`, "1.300"},
+		{`=rounddown(1.29, 1)`, "1.200"},
+		{`=floor(7.5)`, "7.000"},
+		{`=floor(7.5, 2)`, "6.000"},
+		{`=ceil(7.1)`, "8.000"},
+		{`=abs(-3)`, "3"},
+		{`=abs(3)`, "3"},
+		{`=sign(-0.5)`, "-1"},
+		{`=mod(7, 3)`, "1"},
+		{`=mod(-7, 3)`, "2"},
+		{`=mod(7, 0)`, "#DIV/0!"},
+		{`=pow(2, 10)`, "1024.000"},
+		{`=sqrt(16)`, "4.000"},
+		{`=sqrt(-1)`, "#NUM!"},
+		{`=exp(0)`, "1.000"},
+		{`=ln(0)`, "#NUM!"},
+		{`=log10(1000)`, "3.000"},
+		{`=pi()`, "3.142"},
+		{`=int(7.9)`, "7"},
+		{`=int(-7.1)`, "-8"},
+		{`=trunc(7.987, 2)`, "7.980"},
+		{`=abs("-3")`, "3.000"},
+		{`=-abs(-3)`, "-3"},
+		{`=-(1 + 2.5) * 2`, "-7.000"},
+		{`=2 - -1`, "3"},
+		{`=-sqrt(-1)`, "#NUM!"},
+		{`=-"a"`, "#VALUE!"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, evalFormula(t, c.in), c.in)
+	}
+}
+
+func TestRandomFunctionsWithSeed(t *testing.T) {
+	csv, _, err := parser.ParseCSV("=rand()|=randbetween(1, 6)|=randbetween(10, 10)")
+	require.Nil(t, err)
+
+	first := Evaluate(csv, WithSeed(42))
+	second := Evaluate(csv, WithSeed(42))
+	assert.Equal(t, first, second)
+	assert.Equal(t, intValue(10), first[0][2])
+
+	r, ok := first[0][0].(floatValue)
+	assert.True(t, ok)
+	assert.True(t, r >= 0 && r < 1)
+	d, ok := first[0][1].(intValue)
+	assert.True(t, ok)
+	assert.True(t, d >= 1 && d <= 6)
+}
+
+const priceSheet = `!symbol|!price|!chain
+btc|38341.88|bitcoin
+eth|2643.77|ethereum
+dai|1.0003|ethereum
+!summary||
+=vlookup("ETH", @symbol, 2)|=vlookup("sol", @symbol, 2)|=vlookup("eth", A2:C4, 4)
+=hlookup(38341.88, B2:C4, 2)|=xlookup("dai", A2:A4, B2:B4)|=xlookup("sol", A2:A4, B2:B4, "none")
+=xlookup(2000, B2:B4, A2:A4, "none", -1)|=xlookup(2000, B2:B4, A2:A4, "none", 1)|=xlookup("btc", A2:A4, A2:C4)
+=index(@symbol, 3, 3)|=index(A2:C4, 0, 1)|=index(split("a,b,c", ","), 2)
+=match("dai", A2:A4)|=match(3000, B2:B4)|=match(2000, B2:B4, 1)
+=vlookup(2000, B2:C4, 2, 1)|=index(A2:C4, 4, 1)|=match("eth", @price)
+`
+
+func TestLookupFunctions(t *testing.T) {
+	res := evalSheet(t, priceSheet, WithoutSpill())
+	assert.Equal(t, []string{"2643.770", "#N/A", "#REF!"}, res[5])
+	assert.Equal(t, []string{"2643.770", "1.000", "none"}, res[6])
+	assert.Equal(t, []string{"dai", "eth", "[btc, 38341.880, bitcoin]"}, res[7])
+	assert.Equal(t, []string{"ethereum", "[btc, eth, dai]", "b"}, res[8])
+	assert.Equal(t, []string{"3", "#N/A", "3"}, res[9])
+	assert.Equal(t, []string{"ethereum", "#REF!", "#N/A"}, res[10])
+}
+
+const transfersSheet = `!token|!amount|!fee
+eth|1500|2.5
+btc|800|1
+eth|200|0.5
+ETH|3000|4
+dai|1200|
+!summary||
+=sumif(@token, "eth", @amount)|=sumif(B2:B6, ">1000")|=countif(@token, "e*")
+=averageif(@token, "<>eth", @amount)|=countif(C2:C6, "")|=averageif(@token, "sol", @amount)
+=sumifs(@amount, @token, "eth", @amount, ">=1000")|=countifs(@token, "?th", @fee, "<3")|=maxifs(@amount, @token, "eth")
+=minifs(@amount, @token, "eth", @amount, ">300")|=minifs(@amount, @token, "sol")|=sumifs(@amount, A2:A3, "eth")
+`
+
+// a label block ends at a blank row or at a row with labels in any column, blank cells in the label
+// column don't end it, so columns of a block line up as criteria ranges
+func TestLabelBlockBoundary(t *testing.T) {
+	res := evalSheet(t, `!token|!amount
+eth|1
+|2
+btc|3
+|!note
+sol|4
+|
+=len(@token[*])|=sum(@amount[*])
+`)
+	assert.Equal(t, []string{"3", "6.000"}, res[7])
+}
+
+func TestConditionalFunctions(t *testing.T) {
+	res := evalSheet(t, transfersSheet)
+	assert.Equal(t, []string{"4700.000", "5700.000", "3"}, res[7])
+	assert.Equal(t, []string{"1000.000", "1", "#DIV/0!"}, res[8])
+	assert.Equal(t, []string{"4500.000", "2", "3000.000"}, res[9])
+	assert.Equal(t, []string{"1500.000", "0.000", "#VALUE!"}, res[10])
+
+	// incomplete (range, criteria) pairs, the checker reports them unless spread() hides the count
+	res = evalSheet(t, `1|=countifs(A1:A1, 1, A1:A1)|=sumifs(A1:A1, spread(split("1,2,1", ",")))`)
+	assert.Equal(t, []string{"#VALUE!", "#VALUE!"}, res[0][1:])
+}
+
+func TestCriterion(t *testing.T) {
+	cases := []struct {
+		criterion CalculatedValue
+		value     CalculatedValue
+		want      bool
+	}{
+		{stringValue(">1000"), intValue(1001), true},
+		{stringValue(">1000"), stringValue("2000"), false},
+		{stringValue("<=1.5"), floatValue(1.5), true},
+		{stringValue("<>eth"), stringValue("btc"), true},
+		{stringValue("<>eth"), stringValue("Eth"), false},
+		{stringValue("t_*"), stringValue("t_12"), true},
+		{stringValue("t_?"), stringValue("t_12"), false},
+		{stringValue("~*"), stringValue("*"), true},
+		{stringValue("~*"), stringValue("a"), false},
+		{intValue(5), floatValue(5), true},
+		{stringValue("<>"), stringValue(""), false},
+		{stringValue("<>"), intValue(0), true},
+		{stringValue(">b"), stringValue("c"), true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, parseCriterion(c.criterion).matches(c.value), "%v matches %v", c.criterion, c.value)
+	}
+}
+
+func TestCustomFunctions(t *testing.T) {
+	registry := NewFunctionRegistry()
+	err := registry.Register("gasCost", FunctionSpec{
+		MinArgs: 2,
+		MaxArgs: 2,
+		Params:  []ParamType{NumberParam, NumberParam},
+		Fn: func(call *Call, args []CalculatedValue) CalculatedValue {
+			gas, _ := ToNumber(args[0])
+			price, _ := ToNumber(args[1])
+			return NewFloat(gas * price / 1e9)
+		},
+		Doc: "gasCost(gas, gweiPrice) in ETH",
+	})
+	require.Nil(t, err)
+	// lazy function evaluates only the arguments it needs
+	err = registry.Register("firstOf", FunctionSpec{
+		MinArgs: 1,
+		MaxArgs: Variadic,
+		Lazy:    true,
+		LazyFn: func(call *Call, args []m.Expr) CalculatedValue {
+			return call.Eval(args[0])
+		},
+	})
+	require.Nil(t, err)
+
+	// element-wise function gets a single element of a multi first argument at a time
+	err = registry.Register("half", FunctionSpec{
+		MinArgs:     1,
+		MaxArgs:     1,
+		Params:      []ParamType{NumberParam},
+		Returns:     FloatType,
+		ElementWise: true,
+		Fn: func(call *Call, args []CalculatedValue) CalculatedValue {
+			n, _ := ToNumber(args[0])
+			return NewFloat(n / 2)
+		},
+	})
+	require.Nil(t, err)
+
+	csv, _, err := parser.ParseCSV(`=gasCost(21000, 30)|=firstOf(1, nosuchfunction())|=sum(1, 2)|=half({1, 2; 3, 4})|=half(5)`)
+	require.Nil(t, err)
+	res := Evaluate(csv, WithFunctions(registry), WithoutSpill())
+	assert.Equal(t, "0.001", res[0][0].String())
+	assert.Equal(t, "1", res[0][1].String())
+	assert.Equal(t, "3.000", res[0][2].String())
+	assert.Equal(t, "[[0.500, 1.000], [1.500, 2.000]]", res[0][3].String())
+	assert.Equal(t, "2.500", res[0][4].String())
+	sheetTypes := InferTypes(csv, WithFunctions(registry))
+	assert.Equal(t, []ValueType{UnknownType, UnknownType, FloatType, MultiType, FloatType}, sheetTypes.Types[0])
+	assert.Empty(t, sheetTypes.Diagnostics)
+
+	// functions are registered per evaluation
+	_, found := NewFunctionRegistry().Lookup("gasCost")
+	assert.False(t, found)
+	assert.Contains(t, registry.Names(), "gasCost")
+	assert.Contains(t, registry.Names(), "sum")
+}
+
+func TestRegisterInvalidFunction(t *testing.T) {
+	registry := NewFunctionRegistry()
+	fn := func(call *Call, args []CalculatedValue) CalculatedValue { return NewInt(1) }
+	assert.NotNil(t, registry.Register("gas_cost", FunctionSpec{Fn: fn}))
+	assert.NotNil(t, registry.Register("1st", FunctionSpec{Fn: fn}))
+	assert.NotNil(t, registry.Register("noImpl", FunctionSpec{}))
+	assert.NotNil(t, registry.Register("limits", FunctionSpec{MinArgs: 2, MaxArgs: 1, Fn: fn}))
+	assert.Nil(t, registry.Register("ok2", FunctionSpec{MaxArgs: Variadic, Fn: fn}))
+}
+
+func TestFunctionCallValidation(t *testing.T) {
+	cases := []string{
+		`=split("a,b")`,
+		`=incFrom("a")`,
+		`=incFrom(1.5)`,
+		`=sequence(2.5)`,
+		`=round(1, 2, 3)`,
+		`=spread("a")`,
+	}
+
+	for _, c := range cases {
+		assert.Panics(t, func() { evalFormula(t, c) }, c)
+	}
+}
+
+func TestSheetFunctions(t *testing.T) {
+	res := evalSheet(t, `!def|withFee(x) = x + x*@fee<1>|twice(f) = f + f|loop(x) = loop(x)
+!fee|!amount||
+0.5|=A2|=withFee(10)|=twice(withFee(2.0))
+=loop(1)|=sum(1, loop(1))||
+`)
+	assert.Equal(t, []string{"0.500", "!fee", "15.000", "6.000"}, res[2])
+	assert.Equal(t, []string{"#NUM!", "#NUM!", ""}, res[3])
+	assert.Equal(t, "withFee(x) = x + (x * @fee<1>)", res[0][1])
+}
+
+func TestSheetFunctionScope(t *testing.T) {
+	// a referenced cell doesn't see parameters of the calling function
+	csv, _, err := parser.ParseCSV("!def|f(x) = B2\n=f(1)|=x\n")
+	require.Nil(t, err)
+	assert.Panics(t, func() { Evaluate(csv) })
+}
+
+func TestLetAndLambda(t *testing.T) {
+	res := evalSheet(t, `1|2|3|=let(x, 2, y, x*3, x + y)|=let(fee, 0.5, f, lambda(x, x + x*fee), f(10))
+=map(A1:C1, lambda(x, x*10))|=filter(A1:C1, lambda(x, bte(2, x)))|=reduce(0, A1:C1, lambda(acc, x, acc + x*x))|=sum(map(split("1,2", ","), lambda(s, sum(s, 1))))|=map(A1:B1, lambda(x, sqrt(2 - x*x)))
+=lambda(x, x)|=let(f, lambda(f, f(f)), f(f))|=let(x, 1, let(x, 2, x) + x)|=let(n, 2, map(A1:C1, lambda(x, x*n)))|
+`, WithoutSpill())
+	assert.Equal(t, []string{"1", "2", "3", "8", "15.000"}, res[0])
+	assert.Equal(t, []string{"[[10, 20, 30]]", "[2, 3]", "14", "5.000", "[[1.000, #NUM!]]"}, res[1])
+	assert.Equal(t, []string{"lambda(x, x)", "#NUM!", "3", "[[2, 4, 6]]"}, res[2])
+
+	invalid := []string{
+		`=let(f, 1, f(2))`,
+		`=let(f, lambda(x, x), f(1, 2))`,
+		`=map(split("1,2", ","), 1)`,
+	}
+	for _, in := range invalid {
+		csv, _, err := parser.ParseCSV(in)
+		require.Nil(t, err)
+		assert.Panics(t, func() { Evaluate(csv) }, in)
+	}
+}
+
+func TestArrays(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{`={1, 2, 3}`, "[1, 2, 3]"},
+		{`={1, 2; 3, 4}`, "[[1, 2], [3, 4]]"},
+		{`={1, 2, 3} * 2`, "[2, 4, 6]"},
+		{`=10 - {1, 2.5}`, "[9, 7.500]"},
+		{`={1, 2} + {10, 20}`, "[11, 22]"},
+		{`={1, 2; 3, 4} * {1, 10}`, "[[1, 2], [30, 40]]"},
+		{`={1, 2; 3, 4} * {1, 10, 100}`, "#VALUE!"},
+		{`={1, 2} + {1, 2, 3}`, "#VALUE!"},
+		{`={"a", "b"} + "!"`, "[a!, b!]"},
+		{`={1, sqrt(-1)} + 1`, "[2, #NUM!]"},
+		{`={1, 2} / {0, 1}`, "[#DIV/0!, 2]"},
+		{`={1.5, 2} / 0`, "[#DIV/0!, #DIV/0!]"},
+		{`=-{1, -2} * 2`, "[-2, 4]"},
+		{`=sum({1, 2; 3, 4} * 2)`, "20.000"},
+		{`=len({1, 2, 3})`, "3"},
+		{`=len("abc")`, "3"},
+		{`=len({})`, "0"},
+		{`=at({1, 2, 3}, 2)`, "2"},
+		{`=at({1, 2, 3}, -1)`, "3"},
+		{`=at({1, 2; 3, 4}, -1, -2)`, "3"},
+		{`=at({1, 2, 3}, -4)`, "#REF!"},
+		{`=at({1, 2; 3, 4}, 2, 1)`, "3"},
+		{`=at({1, 2, 3}, 4)`, "#REF!"},
+		{`=slice({1, 2, 3, 4}, 2, 2)`, "[2, 3]"},
+		{`=slice({1, 2, 3, 4}, 3)`, "[3, 4]"},
+		{`=slice({1, 2}, 5)`, "[]"},
+		{`=sort({3, "b", 1, "A"})`, "[1, 3, A, b]"},
+		{`=sort({3, 1, 2}, 1)`, "[3, 2, 1]"},
+		{`=sort({"b", 2; "a", 1})`, "[[a, 1], [b, 2]]"},
+		{`=unique({1, 1.0, "a", "A", 2})`, "[1, a, 2]"},
+		{`=reverse({1, 2, 3})`, "[3, 2, 1]"},
+		{`=reverse(mode(1, 2))`, "#N/A"},
+		{`=slice({1, 2, 3, 4}, -2)`, "[3, 4]"},
+		{`=flatten({1, 2; 3, 4}, 5)`, "[1, 2, 3, 4, 5]"},
+		{`=sequence(3)`, "[[1], [2], [3]]"},
+		{`=sequence(1, 3)`, "[1, 2, 3]"},
+		{`=sequence(2, 2, 0, 0.5)`, "[[0.000, 0.500], [1.000, 1.500]]"},
+		{`=sequence(3, 1, 10, -5)`, "[[10], [5], [0]]"},
+		{`=sequence(0)`, "#VALUE!"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, evalFormula(t, c.in), c.in)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	res := evalSheet(t, `={1, 2, 3}|||x
+=sequence(2, 2)||
+||=split("a,b", ",")
+=sum(A1#)|=C3#|=at(A2#, 2, 1)|={1; 2}
+`)
+	assert.Equal(t, [][]string{
+		{"1", "2", "3", "x"},
+		{"1", "2"},
+		{"3", "4", "a", "b"},
+		{"6.000", "#SPILL!", "3", "1"},
+		{"", "", "", "2"},
+	}, res)
+
+	res = evalSheet(t, `|=sequence(2)
+=sequence(1, 3)|
+=A1#|=B1#`)
+	assert.Equal(t, [][]string{
+		{"", "1"},
+		{"#SPILL!", "2"},
+		{"#REF!", "1"},
+		{"", "2"},
+	}, res)
+
+	// references see spilled values, the formula cell is the first element
+	res = evalSheet(t, `={1, 2, 3}||
+=B1 * 10|=sum(A1:C1)|=A1 + C1|=at(A1#, 2)
+=sequence(1, 3)
+=C3 + 1|=D3`)
+	assert.Equal(t, []string{"20", "6.000", "4", "2"}, res[1])
+	assert.Equal(t, []string{"4", "#REF!"}, res[3])
+
+	res = evalSheet(t, `!a|!b|!c
+={1, 2, 3}||
+5|6|7
+||
+=sum(@b[*])|=@c<1>|=sum(@a)`)
+	assert.Equal(t, []string{"8.000", "3", "24.000"}, res[4])
+
+	// A2 might spill into B2, but it can't be calculated while A1 is: it is left for later
+	// instead of becoming #CYCLE!
+	res = evalSheet(t, `=concat(B2, "a")|x
+=concat(A1, "b")||x`)
+	assert.Equal(t, [][]string{{"a", "x"}, {"ab", "", "x"}}, res)
+}
+
+func TestLabelColumnRefs(t *testing.T) {
+	res := evalSheet(t, `!token|!amount
+eth|10
+btc|2.5
+dai|100
+|
+=sum(@amount[*])|=@token[-1]|=@amount[2:3]|=@amount[-2:-1]|=@amount[5]|=count(@amount[3:9])|=@amount[3:2]
+`, WithoutSpill())
+	assert.Equal(t, []string{"112.500", "dai", "[[2.500], [100]]", "[[2.500], [100]]", "#REF!", "1", "[]"}, res[5])
+
+	// last value of a column, while @amount[@-1] is on the row above
+	res = evalSheet(t, `!amount|
+10|
+20|=@amount[@-1]
+|
+=@amount[-1] * 2|=@amount[1]
+`)
+	assert.Equal(t, []string{"20", "10"}, res[2])
+	assert.Equal(t, []string{"40", "10"}, res[4])
+}
+
+func TestLabelCurrentRowRefs(t *testing.T) {
+	res := evalSheet(t, `!amount|!fee|!total
+10|0.5|=@amount[@] + @fee[@]
+20|=@fee[@-1]|=^^
+30|=^^|=@total[@-1] + @amount[@+0]
+=@amount<-1>|=@fee<10>|=@amount[@-10]
+=@amount[@+1]
+`)
+	assert.Equal(t, [][]string{
+		{"!amount", "!fee", "!total"},
+		{"10", "0.500", "10.500"},
+		{"20", "0.500", "20.500"},
+		{"30", "0.500", "50.500"},
+		{"#REF!", "#REF!", "#REF!"},
+		{"#REF!"},
+	}, res)
+
+	res = evalSheet(t, `!amount
+=@nope<1>|=@nope[@]|=@nope[*]|=@nope[-1]|=sum(@nope)
+`)
+	assert.Equal(t, []string{"#REF!", "#REF!", "#REF!", "#REF!", "#REF!"}, res[1])
+}
+
+func TestQualifiedLabels(t *testing.T) {
+	res := evalSheet(t, `!fees|!rate
+1|0.1
+!taxes|!rate|!Rate2|!total cost|!rate
+2|0.2|5||0.3
+=@fees.rate<1>|=@rate<1>|=@taxes.rate<1>|=@Rate2<1>|=@fees.fees<1>
+`)
+	assert.Equal(t, []string{"0.100", "0.300", "0.300", "5", "1"}, res[4])
+
+	// a label with a dot is left out instead of shadowing label b of section a
+	res = evalSheet(t, `!a|!b
+1|2
+!a.b|!c
+3|4
+=@a.b<1>|=@b<1>|=@c<1>|=@a.b.c<1>
+`)
+	assert.Equal(t, []string{"2", "2", "4", "#REF!"}, res[4])
+}
+
+func TestCopyAboveShiftsReferences(t *testing.T) {
+	sheet := `!amount|!rate|!fee|!total
+10|0.5|=A2*$B$2|=sum(A$2:A2)
+20|0.1|=^^|=^^
+30|0.2|=^^|=^^
+=A1|=B9
+=^^|=^^
+`
+	res := evalSheet(t, sheet)
+	assert.Equal(t, [][]string{
+		{"10", "0.500", "5.000", "10.000"},
+		{"20", "0.100", "10.000", "30.000"},
+		{"30", "0.200", "15.000", "60.000"},
+		{"!amount", "#REF!"},
+		{"10", "#REF!"},
+	}, res[1:])
+
+	csv, _, err := parser.ParseCSV(sheet)
+	require.Nil(t, err)
+	explanations := make([]string, 0)
+	for _, e := range Explain(csv) {
+		explanations = append(explanations, e.String())
+	}
+	assert.Equal(t, []string{
+		"C2: =A2 * $B$2 -> 5.000",
+		"D2: =sum(A$2:A2) -> 10.000",
+		"C3: =A3 * $B$2 -> 10.000",
+		"D3: =sum(A$2:A3) -> 30.000",
+		"C4: =A4 * $B$2 -> 15.000",
+		"D4: =sum(A$2:A4) -> 60.000",
+		"A5: =A1 -> !amount",
+		"B5: =B9 -> #REF!",
+		"A6: =A2 -> 10",
+		"B6: =B10 -> #REF!",
+	}, explanations)
+}
+
+func TestR1C1Refs(t *testing.T) {
+	res := evalSheet(t, `1|2|3
+=R1C1 + R1C3|=R[-1]C[1] * 10|=sum(R1C:R[-1]C)
+=RC[1]|=R[-1]C|=R[-3]C|=RC[-2]
+=sum(R1C1:R1C[2])|=R[5]C
+`)
+	assert.Equal(t, [][]string{
+		{"1", "2", "3"},
+		{"4", "30", "3.000"},
+		{"30", "30", "#REF!", "30"},
+		{"6.000", "#REF!"},
+	}, res)
+}
+
+func TestFillDown(t *testing.T) {
+	sheet := `!id|!amount|!double
+=incFrom(1)|10|=B2*2
+=^^*|20|=^^*
+|30
+|40|=B5
+|50
+
+!fee|!gas
+1|2
+=^^*|=^^ *2
+`
+	res := evalSheet(t, sheet)
+	assert.Equal(t, [][]string{
+		{"1", "10", "20"},
+		{"2", "20", "40"},
+		{"3", "30", "60"},
+		{"4", "40", "40"},
+		{"5", "50"},
+		{""},
+		{"!fee", "!gas"},
+		{"1", "2"},
+		{"1", "4"},
+	}, res[1:])
+
+	csv, _, err := parser.ParseCSV(sheet)
+	require.Nil(t, err)
+	assert.Empty(t, Check(csv))
+	assert.Equal(t, []ValueType{IntType, IntType, IntType}, InferTypes(csv).Types[3])
+}
+
+func TestPositionFunctions(t *testing.T) {
+	res := evalSheet(t, `!id|!n|!pos|!since
+=seq(1)|=incFrom(10, 5)|=concat(row(), ":", col())|=rowsSinceLabel("id")
+=seq(100, "0.5")|=^^|=^^|=rowsSinceLabel("nope")
+=^^*|=B^ + incFrom(0)|=^^*|=^^*
+|||
+!x|!y|!z
+=seq(1, 2)|=seq(1, 2)|=incFrom(1)
+=^^|=A^|=C^
+=incFrom(0) + C^|=C^ + incFrom(0)|=^^
+=^^|=^^|=^^
+`)
+	assert.Equal(t, [][]string{
+		{"1", "10", "2:3", "1"},
+		{"100.500", "15", "3:3", "#REF!"},
+		{"101.000", "17", "4:3", "#REF!"},
+		{"", "", ""},
+		{"!x", "!y", "!z"},
+		{"1", "1", "1"},
+		{"3", "1", "1"},
+		{"1", "1", "1"},
+		{"2", "2", "1"},
+	}, res[1:])
+}
+
+func TestWorkbook(t *testing.T) {
+	wb, ok, err := parser.ParseWorkbook(`!sheet|Prices
+!token|!price
+eth|2500
+btc|40000
+!fee
+0.5
+!sheet|Tx
+!amount|!cost
+2|=A2 * Prices!B2 + Prices!@fee<1>
+3|=sum(Prices!@price[*]) + Prices!RC
+=sum(A2:A3)|=Summary!A1
+!sheet|Summary
+=Tx!B4|=Nope!A1
+`, "main")
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	values := EvaluateWorkbook(wb)
+	res := make(map[string][][]string)
+	for _, sheet := range values {
+		res[sheet.Name] = make([][]string, len(sheet.Values))
+		for rowIdx, row := range sheet.Values {
+			for _, v := range row {
+				res[sheet.Name][rowIdx] = append(res[sheet.Name][rowIdx], v.String())
+			}
+		}
+	}
+	assert.Equal(t, []string{"Prices", "Tx", "Summary"}, []string{values[0].Name, values[1].Name, values[2].Name})
+	assert.Equal(t, [][]string{
+		{"!amount", "!cost"},
+		{"2", "5000.500"},
+		{"3", "82500.000"},
+		{"5.000", "#CYCLE!"},
+	}, res["Tx"])
+	assert.Equal(t, [][]string{{"#CYCLE!", "#REF!"}}, res["Summary"])
+
+	assert.Equal(t, []string{"Summary!B1: unknown sheet Nope"}, diagnosticStrings(CheckWorkbook(wb)))
+
+	explanations := ExplainWorkbook(wb)
+	assert.Equal(t, "Tx!B2: =(A2 * Prices!B2) + Prices!@fee<1> -> 5000.500", explanations[0].String())
+}
+
+func TestWorkbookIncludes(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "tx.psv"), []byte("!include|fees.psv\n!amount|!cost\n2|=A2 * fees!@fee<1>\n=A^ + 1|=A1\n"), 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "fees.psv"), []byte("!fee\n0.5\n"), 0644))
+	wb, err := parser.LoadWorkbook(nil, filepath.Join(dir, "tx.psv"))
+	require.Nil(t, err)
+
+	values := EvaluateWorkbook(wb)
+	res := make([][]string, len(values[0].Values))
+	for rowIdx, row := range values[0].Values {
+		for _, v := range row {
+			res[rowIdx] = append(res[rowIdx], v.String())
+		}
+	}
+	// the !include row is not a row of the sheet and doesn't define a label
+	assert.Equal(t, [][]string{
+		{"!amount", "!cost"},
+		{"2", "1.000"},
+		{"3", "!amount"},
+	}, res)
+	assert.Empty(t, CheckWorkbook(wb))
+	sheets := EvaluateWorkbookJSON(wb)
+	assert.Equal(t, JSONCell{Type: "float", Value: 1.0, Formula: "A2 * fees!@fee<1>", Label: "cost"}, sheets[0].Rows[1][1])
+
+	wb.Sheets[0].Cells[2][0] = m.FormulaCell{Formula: m.LabelRelativeRowRef{Label: "include", RelativeRow: 1}}
+	assert.Equal(t, []string{"tx!A3: unknown label @include"}, diagnosticStrings(CheckWorkbook(wb)))
+}
+
+func TestReferenceCycles(t *testing.T) {
+	res := evalSheet(t, `=B1|=A1 + 1|=C1
+=A3|=^^
+=B2
+`)
+	assert.Equal(t, [][]string{
+		{"#CYCLE!", "#CYCLE!", "#CYCLE!"},
+		{"#CYCLE!", "#CYCLE!"},
+		{"#CYCLE!"},
+	}, res)
+}
+
+func TestParams(t *testing.T) {
+	csv, _, err := parser.ParseCSV(`!amount|!fee|!name
+100|=A2 * $fee|=concat($name, "-", param("count") + 1)
+=$missing|=param("nope")|=$fee * $A$2
+`)
+	require.Nil(t, err)
+	params := map[string]m.Cell{
+		"fee":   parser.ParseValue("0.09"),
+		"count": parser.ParseValue("2"),
+		"name":  parser.ParseValue("eth"),
+	}
+	result := EvaluateWithParams(csv, params)
+	res := make([]string, 0)
+	for _, row := range result[1:] {
+		for _, v := range row {
+			res = append(res, v.String())
+		}
+	}
+	assert.Equal(t, []string{"100", "9.000", "eth-3", "#N/A", "#N/A", "9.000"}, res)
+
+	assert.Equal(t, []string{"A3: unknown parameter $missing"}, diagnosticStrings(Check(csv, WithParams(params))))
+	assert.Equal(t, []ValueType{IntType, FloatType, StringType}, InferTypes(csv, WithParams(params)).Types[1])
+}
+
+func TestSweep(t *testing.T) {
+	csv, _, err := parser.ParseCSV(`!amount|!cost
+100|=A2 * $fee + $base
+!too_high
+=text(bte($limit, B2))
+`)
+	require.Nil(t, err)
+	fees, err := parser.ParseParamRange("fee=0.05:0.1:0.05")
+	require.Nil(t, err)
+	limits, err := parser.ParseParamRange("limit=8:10:2")
+	require.Nil(t, err)
+	scenarios := m.Grid([]m.ParamRange{fees, limits, {Name: "base", Values: []m.Cell{m.IntCell{Value: 1}}}})
+
+	outputs := make([]m.Expr, 0)
+	// outputs are evaluated below the sheet, so R[-1]C and @too_high[@-1] are A4
+	for _, text := range []string{"B2", "@too_high<1>", "@nope<1>", "R[-1]C", "@too_high[@-1]", "row()"} {
+		expr, err := parser.ParseExpr(text)
+		require.Nil(t, err)
+		outputs = append(outputs, expr)
+	}
+	res := make([][]string, 0)
+	for _, result := range Sweep(csv, scenarios, outputs) {
+		row := []string{result.Scenario.Name}
+		for _, v := range result.Values {
+			row = append(row, v.String())
+		}
+		res = append(res, row)
+	}
+	assert.Equal(t, [][]string{
+		{"1", "6.000", "false", "#REF!", "false", "false", "5"},
+		{"2", "6.000", "false", "#REF!", "false", "false", "5"},
+		{"3", "11.000", "true", "#REF!", "true", "true", "5"},
+		{"4", "11.000", "true", "#REF!", "true", "true", "5"},
+	}, res)
+
+	assert.Equal(t, []string{"@nope<1>: unknown label @nope"},
+		CheckOutputs(m.Workbook{Sheets: []m.Sheet{{Cells: csv}}}, outputs, WithParams(scenarios[0].Params)))
+
+	wb := m.Workbook{Sheets: []m.Sheet{{Cells: csv}}}
+	assert.Equal(t, []string{"rate"}, UnusedParams(wb, outputs, []string{"base", "fee", "limit", "rate"}))
+	rate, err := parser.ParseExpr(`param("rate") * 2`)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"x"}, UnusedParams(wb, []m.Expr{rate}, []string{"base", "rate", "x"}))
+	// a calculated name might be any parameter
+	anyParam, err := parser.ParseExpr(`param(concat("ra", "te"))`)
+	require.Nil(t, err)
+	assert.Empty(t, UnusedParams(wb, []m.Expr{anyParam}, []string{"base", "rate", "x"}))
+}
+
+func TestGoalSeek(t *testing.T) {
+	csv, _, err := parser.ParseCSV(`!fee|!cost|!limit
+0.05|=100 * A2 + 1|=sqrt(B2 - 8)
+!too_high|!step
+=text(bte(8, @cost<1>))|=floor(@fee<1> * 5)
+`)
+	require.Nil(t, err)
+	parse := func(text string) m.Expr {
+		expr, err := parser.ParseExpr(text)
+		require.Nil(t, err)
+		return expr
+	}
+	res, err := Seek(csv, GoalSeek{Input: parse("A2"), Target: parse("B2"), Value: 11})
+	require.Nil(t, err)
+	assert.InDelta(t, 0.1, res.Input, 1e-9)
+	assert.Equal(t, "11.000", res.Target.String())
+
+	res, err = Seek(csv, GoalSeek{Input: parse("@fee<1>"), Target: parse("@too_high<1>"), Value: 1, Tolerance: 1e-6})
+	require.Nil(t, err)
+	assert.InDelta(t, 0.07, res.Input, 1e-6)
+	assert.Equal(t, "true", res.Target.String())
+
+	_, err = Seek(csv, GoalSeek{Input: parse("A2"), Target: parse("B2"), Value: 11, Low: 0.2, High: 1})
+	assert.EqualError(t, err, "goal seek failed: target doesn't reach 11 between 0.2 and 1; closest: input 0.2 gives 21.000 after 4 evaluation(s)")
+
+	_, err = Seek(csv, GoalSeek{Input: parse("A2"), Target: parse("C2"), Value: 10})
+	assert.EqualError(t, err, "goal seek failed: target C2 is not a number for input 0.05: #NUM!")
+
+	_, err = Seek(csv, GoalSeek{Input: parse("A2"), Target: parse("@step<1>"), Value: 0.5})
+	assert.ErrorContains(t, err, "goal seek failed: target jumps over 0.5 at input 0.2")
+
+	_, err = Seek(csv, GoalSeek{Input: parse("A2"), Target: parse("B2"), Value: 11, MaxIterations: 1})
+	assert.EqualError(t, err, "goal seek failed: no solution within 1 evaluations; closest: input 0.05 gives 6.000 after 1 evaluation(s)")
+
+	_, err = Seek(csv, GoalSeek{Input: parse("B2"), Target: parse("B2"), Value: 11})
+	assert.EqualError(t, err, "goal seek failed: input B2 must be a number cell")
+	_, err = Seek(csv, GoalSeek{Input: parse("@nope<1>"), Target: parse("B2"), Value: 11})
+	assert.EqualError(t, err, "goal seek failed: unknown label @nope")
+}
+
+func TestEvaluateJSON(t *testing.T) {
+	csv, _, err := parser.ParseCSV(`!amount|!fee
+100|0.5
+=^^|=sqrt(0-A3)
+=sequence(2)||
+`)
+	require.Nil(t, err)
+	res, err := json.Marshal(EvaluateJSON(csv))
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"rows": [
+		[{"type": "label", "value": "amount"}, {"type": "label", "value": "fee"}],
+		[{"type": "int", "value": 100, "label": "amount"}, {"type": "float", "value": 0.5, "label": "fee"}],
+		[
+			{"type": "int", "value": 100, "label": "amount"},
+			{"type": "error", "code": "#NUM!", "formula": "sqrt(0 - A3)", "label": "fee"}
+		],
+		[
+			{"type": "int", "value": 1, "formula": "sequence(2)", "label": "amount"},
+			{"type": "string", "value": "", "label": "fee"}
+		],
+		[{"type": "int", "value": 2, "label": "amount"}]
+	]}`, string(res))
+
+	sheets := EvaluateWorkbookJSON(m.Workbook{Sheets: []m.Sheet{{Name: "Main", Cells: csv}}}, WithoutSpill())
+	require.Len(t, sheets, 1)
+	assert.Equal(t, "Main", sheets[0].Name)
+	assert.Equal(t, JSONCell{
+		Type: "multi",
+		Value: []JSONCell{
+			{Type: "multi", Value: []JSONCell{{Type: "int", Value: 1}}},
+			{Type: "multi", Value: []JSONCell{{Type: "int", Value: 2}}},
+		},
+		Formula: "sequence(2)",
+		Label:   "amount",
+	}, sheets[0].Rows[3][0])
+}
@@ -0,0 +1,47 @@
+package evaluator
+
+import (
+	"fmt"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// Explanation shows the formula evaluated for a cell, for copied formulas (^^) it's the formula
+// with references moved to the cell
+type Explanation struct {
+	Sheet   string // set for workbooks
+	Row     int    // 0-based
+	Col     int    // 0-based
+	Formula m.Expr
+	Value   CalculatedValue
+}
+
+func (e Explanation) String() string {
+	return fmt.Sprintf("%s: =%v -> %v", cellPosition(e.Sheet, e.Row, e.Col), e.Formula, e.Value)
+}
+
+// Explain evaluates the sheet and lists formulas of all cells that have one, row by row
+func Explain(cells CSVCells, options ...Option) []Explanation {
+	es := prepareState(cells, options)
+	calculateAll(&es, es.csvCells)
+	return explanations(&es)
+}
+
+// formulas of calculated cells
+func explanations(es *evalState) []Explanation {
+	res := make([]Explanation, 0)
+	for rowIdx, row := range es.evalCells {
+		for colIdx, cell := range row {
+			if cell.formula == nil {
+				continue
+			}
+			res = append(res, Explanation{
+				Row:     rowIdx,
+				Col:     colIdx,
+				Formula: *cell.formula,
+				Value:   cell.value,
+			})
+		}
+	}
+	return res
+}
@@ -0,0 +1,65 @@
+package evaluator
+
+import (
+	m "pasza.org/sr-challenge/model"
+)
+
+// =^^* fills the rest of the column: the marker cell and the blank cells below it copy the formula
+// above like =^^, row by row, until a row with labels, a blank row or a cell with content.
+// Filled cells are added to the sheet before evaluation, so copyCount of incFrom() keeps counting
+// through them.
+
+func rowHasLabel(row []m.Cell) bool {
+	for _, cell := range row {
+		if _, ok := cell.(m.LabelCell); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func rowIsBlank(row []m.Cell) bool {
+	for _, cell := range row {
+		if s, ok := cell.(m.StringCell); !ok || s.Value != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func isFillDown(cell m.Cell) bool {
+	formula, ok := cell.(m.FormulaCell)
+	if !ok {
+		return false
+	}
+	_, ok = formula.Formula.(m.FillDown)
+	return ok
+}
+
+// copy of the sheet with =^^* columns filled with =^^, rows are copied only when changed
+func fillColumns(cells CSVCells) CSVCells {
+	res := append(make(CSVCells, 0, len(cells)), cells...)
+	copyAbove := m.FormulaCell{Formula: m.CopyAbove{}}
+	for rowIdx, row := range cells {
+		for colIdx, cell := range row {
+			if !isFillDown(cell) {
+				continue
+			}
+			res[rowIdx] = append(make([]m.Cell, 0, len(res[rowIdx])), res[rowIdx]...)
+			res[rowIdx][colIdx] = copyAbove
+			for fillRowIdx := rowIdx + 1; fillRowIdx < len(cells); fillRowIdx++ {
+				fillRow := cells[fillRowIdx]
+				if rowHasLabel(fillRow) || rowIsBlank(fillRow) || !isBlankCell(cells, fillRowIdx, colIdx) {
+					break
+				}
+				newRow := append(make([]m.Cell, 0, len(res[fillRowIdx])), res[fillRowIdx]...)
+				for len(newRow) <= colIdx {
+					newRow = append(newRow, m.StringCell{Value: ""})
+				}
+				newRow[colIdx] = copyAbove
+				res[fillRowIdx] = newRow
+			}
+		}
+	}
+	return res
+}
@@ -2,98 +2,185 @@ package evaluator
 
 import (
 	"bytes"
-	"strconv"
+	"fmt"
 	"strings"
 
 	m "pasza.org/sr-challenge/model"
 )
 
-var supportedFunctions map[string](func(*evalState, []CalculatedValue, int, int) CalculatedValue)
+// builtin functions, see FunctionRegistry for adding custom ones
+var supportedFunctions *FunctionRegistry
 
 func init() {
-	supportedFunctions = map[string](func(*evalState, []CalculatedValue, int, int) CalculatedValue){
-		"sum":     sum,
-		"bte":     bte,
-		"text":    text,
-		"incFrom": incFrom,
-		"concat":  concat,
-		"split":   split,
-		"spread":  spread,
+	supportedFunctions = newFunctionRegistry(nil)
+	for name, spec := range builtinFunctionSpecs() {
+		supportedFunctions.mustRegister(name, spec)
 	}
 }
 
-func incFrom(es *evalState, args []CalculatedValue, rowIdx int, colIdx int) CalculatedValue {
-	ec := es.evalCells[rowIdx][colIdx]
-	if len(args) != 1 {
-		panic("Function incFrom() requires exactly one argument")
-	}
-	v, ok := args[0].(intValue)
-	if !ok {
-		panic("Function incFrom() expects int argument")
-	}
+// shorthand for fixed arity functions
+func fixed(fn Function, returns ValueType, doc string, params ...ParamType) FunctionSpec {
+	return FunctionSpec{MinArgs: len(params), MaxArgs: len(params), Params: params, Returns: returns, Fn: fn, Doc: doc}
+}
 
-	return intValue(v + intValue(ec.copyCount))
+// shorthand for functions with optional or repeated arguments
+func ranged(fn Function, minArgs, maxArgs int, returns ValueType, doc string, params ...ParamType) FunctionSpec {
+	return FunctionSpec{MinArgs: minArgs, MaxArgs: maxArgs, Params: params, Returns: returns, Fn: fn, Doc: doc}
 }
 
-func sum(es *evalState, args []CalculatedValue, rowIdx int, colIdx int) CalculatedValue {
-	res := 0.0
-	for _, arg := range args {
-		switch v := arg.(type) {
-		case intValue:
-			res += float64(v)
-		case floatValue:
-			res += float64(v)
-		case stringValue:
-			f, err := strconv.ParseFloat(string(v), 64)
-			if err != nil {
-				panic("Couldn't convert sum() argument to a number")
-			}
-			res += f
-		default:
-			panic("Unknown argument type passed to sum()")
+// arguments after the leading ones come in (range, criteria) pairs
+func criteriaPairs(leading int, spec FunctionSpec) FunctionSpec {
+	spec.CheckArgCount = func(argc int) string {
+		if (argc-leading)%2 != 0 {
+			return "expects pairs of range and criteria arguments"
 		}
+		return ""
 	}
-	return floatValue(res)
+	return spec
 }
 
-func bte(es *evalState, args []CalculatedValue, rowIdx int, colIdx int) CalculatedValue {
-	if len(args) != 2 {
-		panic("Function bte() expects exacltly two arguments")
-	}
-
-	return bte_(args[0], args[1])
+func elementWise(spec FunctionSpec) FunctionSpec {
+	spec.ElementWise = true
+	return spec
 }
 
-func text(es *evalState, args []CalculatedValue, rowIdx int, colIdx int) CalculatedValue {
-	if len(args) != 1 {
-		panic("Function text() expects exactly one argument")
+func builtinFunctionSpecs() map[string]FunctionSpec {
+	const (
+		anyP   = AnyParam
+		numP   = NumberParam
+		intP   = IntParam
+		strP   = StringParam
+		boolP  = BoolParam
+		multiP = MultiParam
+		fnP    = LambdaParam
+	)
+	return map[string]FunctionSpec{
+		"sum":     ranged(sum, 0, Variadic, FloatType, "sum(values...) adds numbers", anyP),
+		"bte":     fixed(bte, BoolType, "bte(a, b) is true when a <= b", numP, numP),
+		"text":    fixed(text, StringType, "text(value) converts value to text", anyP),
+		"incFrom": ranged(incFrom, 1, 2, IntType, "incFrom(start, [step=1]) is start plus step for each copy of the formula above", intP, intP),
+		"concat":  ranged(concat, 0, Variadic, StringType, "concat(values...) joins values as text", anyP),
+		"split":   fixed(split, MultiType, "split(text, separator) splits text into a multi value", strP, strP),
+		"spread":  fixed(spread, MultiType, "spread(multi) passes elements as separate arguments", multiP),
+
+		"at":       ranged(at, 2, 3, UnknownType, "at(array, index, [column]) is the element at 1-based index, negative ones count from the end", multiP, intP),
+		"slice":    ranged(slice, 2, 3, MultiType, "slice(array, start, [count]) takes elements from 1-based start", multiP, intP),
+		"sort":     ranged(sortValues, 1, 2, MultiType, "sort(array, [descending=false]) orders elements, rows by their first element", multiP, boolP),
+		"unique":   fixed(unique, MultiType, "unique(array) drops repeated elements", multiP),
+		"reverse":  fixed(reverse, MultiType, "reverse(array) reverses order of elements", multiP),
+		"flatten":  ranged(flatten, 1, Variadic, MultiType, "flatten(values...) joins elements of all arrays into a flat array", anyP),
+		"sequence": ranged(sequence, 1, 4, MultiType, "sequence(rows, [columns=1], [start=1], [step=1]) is an array of numbers", intP, intP, numP),
+
+		"row":            fixed(row, IntType, "row() is the 1-based row of the formula cell"),
+		"col":            fixed(col, IntType, "col() is the 1-based column of the formula cell"),
+		"rowsSinceLabel": fixed(rowsSinceLabel, IntType, `rowsSinceLabel("label") counts rows from the label to the formula cell`, strP),
+		"param":          fixed(param, UnknownType, `param("name") is the value of a parameter given for the evaluation, #N/A if missing`, strP),
+		"seq":            ranged(seq, 1, 2, UnknownType, "seq(start, [step=1]) is start on the first row below labels, plus step on each next row", numP, numP),
+
+		"map":    fixed(mapValues, MultiType, "map(values, lambda(x, ...)) applies lambda to each element", multiP, fnP),
+		"filter": fixed(filter, MultiType, "filter(values, lambda(x, ...)) keeps elements the lambda is true for", multiP, fnP),
+		"reduce": fixed(reduce, UnknownType, "reduce(initial, values, lambda(acc, x, ...)) folds elements into one value", anyP, multiP, fnP),
+
+		"avg":        ranged(avg, 0, Variadic, FloatType, "avg(values...) is the arithmetic mean, #DIV/0! for no values", anyP),
+		"min":        ranged(minOf, 0, Variadic, FloatType, "min(values...) is the smallest number, 0 for no values", anyP),
+		"max":        ranged(maxOf, 0, Variadic, FloatType, "max(values...) is the largest number, 0 for no values", anyP),
+		"count":      ranged(count, 0, Variadic, IntType, "count(values...) counts numbers", anyP),
+		"counta":     ranged(counta, 0, Variadic, IntType, "counta(values...) counts non-blank values", anyP),
+		"countblank": ranged(countblank, 0, Variadic, IntType, "countblank(values...) counts blank values", anyP),
+		"product":    ranged(product, 0, Variadic, FloatType, "product(values...) multiplies numbers, 0 for no values", anyP),
+		"median":     ranged(median, 0, Variadic, FloatType, "median(values...) is the middle number, #NUM! for no values", anyP),
+		"mode":       ranged(mode, 0, Variadic, FloatType, "mode(values...) is the most frequent number, #N/A if none repeats", anyP),
+		"stdev":      ranged(stdev, 0, Variadic, FloatType, "stdev(values...) is the sample standard deviation", anyP),
+		"var":        ranged(variance, 0, Variadic, FloatType, "var(values...) is the sample variance", anyP),
+
+		"upper":      elementWise(fixed(upper, StringType, "upper(text) converts text to upper case", anyP)),
+		"lower":      elementWise(fixed(lower, StringType, "lower(text) converts text to lower case", anyP)),
+		"trim":       elementWise(fixed(trim, StringType, "trim(text) removes leading and trailing white space", anyP)),
+		"len":        fixed(length, IntType, "len(value) is the number of characters of text or elements of an array", anyP),
+		"left":       elementWise(ranged(left, 1, 2, StringType, "left(text, [count=1]) takes first characters", anyP, intP)),
+		"right":      elementWise(ranged(right, 1, 2, StringType, "right(text, [count=1]) takes last characters", anyP, intP)),
+		"mid":        elementWise(fixed(mid, StringType, "mid(text, start, count) takes characters from 1-based start", anyP, intP, intP)),
+		"find":       ranged(find, 2, 3, IntType, "find(needle, text, [start=1]) is 1-based position of needle, #VALUE! if missing", anyP, anyP, intP),
+		"replace":    elementWise(fixed(replace, StringType, "replace(text, start, count, newText) replaces characters at position", anyP, intP, intP, anyP)),
+		"substitute": elementWise(ranged(substitute, 3, 4, StringType, "substitute(text, old, new, [instance]) replaces occurrences of old", anyP, anyP, anyP, intP)),
+		"repeat":     elementWise(fixed(repeat, StringType, "repeat(text, count) repeats text", anyP, intP)),
+		"join":       ranged(join, 1, Variadic, StringType, "join(separator, values...) joins values with separator", anyP),
+		"startsWith": elementWise(fixed(startsWith, BoolType, "startsWith(text, prefix)", anyP, anyP)),
+		"endsWith":   elementWise(fixed(endsWith, BoolType, "endsWith(text, suffix)", anyP, anyP)),
+		"contains":   elementWise(fixed(contains, BoolType, "contains(text, needle)", anyP, anyP)),
+		"pad":        elementWise(ranged(pad, 2, 3, StringType, "pad(text, width, [fill=\" \"]) pads on the left, or on the right for negative width", anyP, intP, anyP)),
+
+		"round":       ranged(round, 1, 2, FloatType, "round(x, [digits=0]) rounds half away from zero", numP, intP),
+		"roundup":     ranged(roundup, 1, 2, FloatType, "roundup(x, [digits=0]) rounds away from zero", numP, intP),
+		"rounddown":   ranged(rounddown, 1, 2, FloatType, "rounddown(x, [digits=0]) rounds towards zero", numP, intP),
+		"floor":       ranged(floor, 1, 2, FloatType, "floor(x, [significance=1]) rounds down to a multiple of significance", numP, numP),
+		"ceil":        ranged(ceil, 1, 2, FloatType, "ceil(x, [significance=1]) rounds up to a multiple of significance", numP, numP),
+		"abs":         fixed(abs, UnknownType, "abs(x) is the absolute value", numP),
+		"sign":        fixed(sign, IntType, "sign(x) is -1, 0 or 1", numP),
+		"mod":         fixed(mod, UnknownType, "mod(x, divisor) is the remainder with the sign of divisor", numP, numP),
+		"pow":         fixed(pow, FloatType, "pow(base, exponent)", numP, numP),
+		"sqrt":        fixed(sqrt, FloatType, "sqrt(x) is the square root, #NUM! for negative x", numP),
+		"exp":         fixed(exp, FloatType, "exp(x) is e to the power of x", numP),
+		"ln":          fixed(ln, FloatType, "ln(x) is the natural logarithm", numP),
+		"log10":       fixed(log10, FloatType, "log10(x) is the base 10 logarithm", numP),
+		"pi":          fixed(pi, FloatType, "pi() is 3.14159..."),
+		"int":         fixed(toInt, IntType, "int(x) rounds down to an integer", numP),
+		"trunc":       ranged(trunc, 1, 2, FloatType, "trunc(x, [digits=0]) drops digits past the given one", numP, intP),
+		"rand":        fixed(random, FloatType, "rand() is a random number in [0, 1)"),
+		"randbetween": fixed(randbetween, IntType, "randbetween(low, high) is a random integer in [low, high]", numP, numP),
+
+		"vlookup": ranged(vlookup, 3, 4, UnknownType, "vlookup(key, table, column, [approximate=false]) looks key up in the first column", anyP, anyP, intP, boolP),
+		"hlookup": ranged(hlookup, 3, 4, UnknownType, "hlookup(key, table, row, [approximate=false]) looks key up in the first row", anyP, anyP, intP, boolP),
+		"xlookup": ranged(xlookup, 3, 5, UnknownType, "xlookup(key, lookupValues, returnValues, [ifNotFound], [mode=0])", anyP, anyP, anyP, anyP, intP),
+		"index":   ranged(index, 2, 3, UnknownType, "index(table, row, [column]) is the value at 1-based position", anyP, intP, intP),
+		"match":   ranged(match, 2, 3, IntType, "match(key, values, [type=0]) is 1-based position of key", anyP, anyP, intP),
+
+		"sumif":     ranged(sumif, 2, 3, FloatType, "sumif(range, criteria, [sumRange])", anyP),
+		"countif":   fixed(countif, IntType, "countif(range, criteria)", anyP, anyP),
+		"averageif": ranged(averageif, 2, 3, FloatType, "averageif(range, criteria, [averageRange])", anyP),
+		"sumifs":    criteriaPairs(1, ranged(sumifs, 3, Variadic, FloatType, "sumifs(sumRange, range1, criteria1, ...)", anyP)),
+		"countifs":  criteriaPairs(0, ranged(countifs, 2, Variadic, IntType, "countifs(range1, criteria1, ...)", anyP)),
+		"maxifs":    criteriaPairs(1, ranged(maxifs, 3, Variadic, FloatType, "maxifs(maxRange, range1, criteria1, ...)", anyP)),
+		"minifs":    criteriaPairs(1, ranged(minifs, 3, Variadic, FloatType, "minifs(minRange, range1, criteria1, ...)", anyP)),
 	}
-	return stringValue(args[0].String())
 }
 
-func spread(es *evalState, args []CalculatedValue, rowIdx int, colIdx int) CalculatedValue {
-	if len(args) != 1 {
-		panic("Function spread() expects exactly one argument")
+func sum(call *Call, args []CalculatedValue) CalculatedValue {
+	nums, failure := numericArgs("sum", args)
+	if failure != "" {
+		return failure
 	}
-	mv, ok := args[0].(multiValue)
-	if !ok {
-		panic("Function spread() expectes argument of multivalue type")
+	res := 0.0
+	for _, n := range nums {
+		res += n
 	}
-	return spreadValue(mv)
+	return floatValue(res)
 }
 
-func split(es *evalState, args []CalculatedValue, rowIdx int, colIdx int) CalculatedValue {
-	if len(args) != 2 {
-		panic("Function split() expects exactly two arguments")
+func bte(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
 	}
-	s, ok := args[0].(stringValue)
-	if !ok {
-		panic("Function split() first argument must be a string value")
+	// numeric strings are accepted as numbers
+	return bte_(numericValue("bte", args[0]), numericValue("bte", args[1]))
+}
+
+func text(call *Call, args []CalculatedValue) CalculatedValue {
+	return stringValue(args[0].String())
+}
+
+func spread(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
 	}
-	sep, ok := args[1].(stringValue)
-	if !ok {
-		panic("Function split() second argument must be a string value")
+	return spreadValue(args[0].(multiValue))
+}
+
+func split(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
 	}
+	s, sep := args[0].(stringValue), args[1].(stringValue)
 	parts := strings.Split(string(s), string(sep))
 	res := make([]CalculatedValue, len(parts))
 	for i, part := range parts {
@@ -102,7 +189,10 @@ func split(es *evalState, args []CalculatedValue, rowIdx int, colIdx int) Calcul
 	return multiValue(res)
 }
 
-func concat(es *evalState, args []CalculatedValue, rowIdx int, colIdx int) CalculatedValue {
+func concat(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
 	var buff bytes.Buffer
 	for _, v := range args {
 		buff.WriteString(v.String())
@@ -111,22 +201,55 @@ func concat(es *evalState, args []CalculatedValue, rowIdx int, colIdx int) Calcu
 }
 
 func calcFunCall(es *evalState, v m.FunCall, rowIdx int, colIdx int) CalculatedValue {
+	if value, ok := es.scope.lookup(v.Name); ok {
+		return calcLambdaCall(es, v, value, rowIdx, colIdx)
+	}
+	spec, ok := es.functions.Lookup(v.Name)
+	if !ok {
+		panic("Function not found: " + v.Name)
+	}
+	call := &Call{
+		Name: v.Name,
+		Row:  rowIdx,
+		Col:  colIdx,
+		es:   es,
+	}
+	if spec.Lazy {
+		if !spec.AcceptsArgCount(len(v.Params)) {
+			panic(fmt.Sprintf("Function %s() expects %s, got %d", v.Name, spec.arityString(), len(v.Params)))
+		}
+		return spec.LazyFn(call, v.Params)
+	}
+
 	args := make([]CalculatedValue, 0)
 	for _, expr := range v.Params {
 		arg := calcExpr(es, &expr, rowIdx, colIdx)
 		if spreadArg, ok := arg.(spreadValue); ok {
-			for _, a := range spreadArg {
-				args = append(args, a)
-			}
+			args = append(args, spreadArg...)
 		} else {
-			args = append(args, calcExpr(es, &expr, rowIdx, colIdx))
+			args = append(args, arg)
 		}
 	}
+	return applyFunction(call, spec, args)
+}
 
-	f, ok := supportedFunctions[v.Name]
-	if !ok {
-		panic("Function not found: " + v.Name)
+// calls the function, once for each element of a multi first argument when it's element-wise
+func applyFunction(call *Call, spec FunctionSpec, args []CalculatedValue) CalculatedValue {
+	if mv, ok := firstMulti(args); ok && spec.ElementWise {
+		res := make(multiValue, len(mv))
+		for i, elem := range mv {
+			res[i] = applyFunction(call, spec, append([]CalculatedValue{elem}, args[1:]...))
+		}
+		return res
 	}
+	spec.validate(call.Name, args)
+	return spec.Fn(call, args)
+}
 
-	return f(es, args, rowIdx, colIdx)
+func firstMulti(args []CalculatedValue) (multiValue, bool) {
+	if len(args) == 0 {
+		return nil, false
+	}
+	mv, ok := args[0].(multiValue)
+	return mv, ok
 }
@@ -0,0 +1,342 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// GoalSeek describes a search for the value of an input cell making a target reach a value.
+// Booleans (also "true" and "false" texts) count as 1 and 0, for them the search finds the input
+// where the target flips to the wanted value.
+type GoalSeek struct {
+	Input  m.Expr // number cell of the first sheet, e.g. A6 or @fee<1>
+	Target m.Expr // evaluated as if written below the sheet, like sweep outputs
+	Value  float64
+
+	// range to search in, around the current input value when both are 0
+	Low  float64
+	High float64
+
+	Tolerance     float64 // of the target, or of the input for boolean targets; 1e-9 when 0
+	MaxIterations int     // evaluations of the sheet, 100 when 0
+}
+
+// GoalSeekResult is the input value found and the target value it gives
+type GoalSeekResult struct {
+	Input       float64
+	Target      CalculatedValue
+	Evaluations int
+}
+
+func (r GoalSeekResult) String() string {
+	return fmt.Sprintf("input %s gives %v after %d evaluation(s)", strconv.FormatFloat(r.Input, 'f', -1, 64), r.Target, r.Evaluations)
+}
+
+// GoalSeekError tells why the search failed, with the closest result found
+type GoalSeekError struct {
+	Reason  string
+	Closest *GoalSeekResult
+}
+
+func (e GoalSeekError) Error() string {
+	if e.Closest == nil {
+		return fmt.Sprintf("goal seek failed: %s", e.Reason)
+	}
+	return fmt.Sprintf("goal seek failed: %s; closest: %v", e.Reason, *e.Closest)
+}
+
+type goalSeeker struct {
+	wb      m.Workbook
+	goal    GoalSeek
+	options []Option
+	rowIdx  int
+	colIdx  int
+	boolean bool // target is a boolean, searched for the flip
+	best    *GoalSeekResult
+	bestErr float64
+	calls   int
+	values  map[float64]CalculatedValue // target values by input
+}
+
+// number cell the input refers to, in the first sheet
+func inputPosition(es *evalState, input m.Expr) (int, int, error) {
+	var rowIdx, colIdx int
+	switch v := input.(type) {
+	case m.CellRef:
+		rowIdx, colIdx = v.Row-1, colNameToIdx(v.Col)
+	case m.LabelRelativeRowRef:
+		lastRowIdx := len(es.labelsOnRow) - 1
+		if v.FromCurrentRow || lastRowIdx < 0 {
+			return 0, 0, fmt.Errorf("input must be a cell like A6 or @fee<1>, got %v", input)
+		}
+		label, found := es.labelsOnRow[lastRowIdx][v.Label]
+		if !found {
+			return 0, 0, fmt.Errorf("unknown label @%s", v.Label)
+		}
+		rowIdx, colIdx = label.rowIdx+v.RelativeRow, label.colIdx
+	default:
+		return 0, 0, fmt.Errorf("input must be a cell like A6 or @fee<1>, got %v", input)
+	}
+	if !isInSheet(es, rowIdx, colIdx) {
+		return 0, 0, fmt.Errorf("input %v is outside of the sheet", input)
+	}
+	return rowIdx, colIdx, nil
+}
+
+// value of the input cell, which has to be a number
+func inputValue(cell m.Cell) (float64, bool) {
+	switch v := cell.(type) {
+	case m.IntCell:
+		return float64(v.Value), true
+	case m.FloatCell:
+		return v.Value, true
+	default:
+		return 0, false
+	}
+}
+
+// target as a number, booleans as 1 and 0
+func goalNumber(v CalculatedValue) (float64, bool, bool) {
+	switch v := v.(type) {
+	case intValue:
+		return float64(v), false, true
+	case floatValue:
+		return float64(v), false, true
+	case boolValue, stringValue:
+		switch v.String() {
+		case "true":
+			return 1, true, true
+		case "false":
+			return 0, true, true
+		}
+	}
+	return 0, false, false
+}
+
+// target value with the input set to x
+func (s *goalSeeker) eval(x float64) (CalculatedValue, error) {
+	if v, ok := s.values[x]; ok {
+		return v, nil
+	}
+	if s.calls >= s.goal.MaxIterations {
+		return nil, s.fail(fmt.Sprintf("no solution within %d evaluations", s.goal.MaxIterations))
+	}
+	s.calls++
+	sheets := append([]m.Sheet{}, s.wb.Sheets...)
+	cells := append(CSVCells{}, sheets[0].Cells...)
+	cells[s.rowIdx] = append([]m.Cell{}, cells[s.rowIdx]...)
+	cells[s.rowIdx][s.colIdx] = m.FloatCell{Value: x}
+	sheets[0].Cells = cells
+	es := prepareWorkbook(m.Workbook{Sheets: sheets}, s.options)[0]
+	v := calcOutput(es, s.goal.Target)
+	s.values[x] = v
+	return v, nil
+}
+
+// signed distance of the target from the goal at x; for booleans it's never 0, the sign tells
+// on which side of the flip x is
+func (s *goalSeeker) f(x float64) (float64, error) {
+	v, err := s.eval(x)
+	if err != nil {
+		return 0, err
+	}
+	n, boolean, ok := goalNumber(v)
+	if !ok {
+		return 0, s.fail(fmt.Sprintf("target %v is not a number for input %s: %v", s.goal.Target, strconv.FormatFloat(x, 'f', -1, 64), v))
+	}
+	s.boolean = boolean
+	diff := n - s.goal.Value
+	if boolean {
+		diff = n - 0.5
+		if s.goal.Value < 0.5 {
+			diff = -diff
+		}
+	}
+	// for booleans any input giving the wanted value is closer than the ones that don't
+	if !s.inRange(x) {
+		return diff, nil
+	}
+	if s.best == nil || math.Abs(diff) < s.bestErr || (boolean && diff > 0) {
+		s.best = &GoalSeekResult{Input: x, Target: v}
+		s.bestErr = math.Abs(diff)
+	}
+	return diff, nil
+}
+
+func (s *goalSeeker) found(x float64, diff float64) bool {
+	return !s.boolean && math.Abs(diff) <= s.goal.Tolerance
+}
+
+func (s *goalSeeker) goalText() string {
+	if s.boolean {
+		return strconv.FormatBool(s.goal.Value >= 0.5)
+	}
+	return strconv.FormatFloat(s.goal.Value, 'f', -1, 64)
+}
+
+// error with the closest result so far
+func (s *goalSeeker) fail(reason string) GoalSeekError {
+	err := GoalSeekError{Reason: reason}
+	if s.best != nil {
+		closest := *s.best
+		closest.Evaluations = s.calls
+		err.Closest = &closest
+	}
+	return err
+}
+
+func (s *goalSeeker) result(x float64) GoalSeekResult {
+	return GoalSeekResult{Input: x, Target: s.values[x], Evaluations: s.calls}
+}
+
+func (s *goalSeeker) inRange(x float64) bool {
+	if s.goal.Low == 0 && s.goal.High == 0 {
+		return true
+	}
+	return x >= math.Min(s.goal.Low, s.goal.High) && x <= math.Max(s.goal.Low, s.goal.High)
+}
+
+// secant method from the current input value, fast for smooth targets
+func (s *goalSeeker) secant(x0 float64) (float64, bool, error) {
+	a, b := x0, x0+math.Max(math.Abs(x0)*0.01, 0.001)
+	fa, err := s.f(a)
+	if err != nil || s.found(a, fa) {
+		return a, err == nil, err
+	}
+	fb, err := s.f(b)
+	for err == nil && !s.boolean {
+		if s.found(b, fb) {
+			return b, true, nil
+		}
+		if fb == fa {
+			break
+		}
+		c := b - fb*(b-a)/(fb-fa)
+		if math.IsNaN(c) || math.IsInf(c, 0) || !s.inRange(c) {
+			break
+		}
+		a, fa = b, fb
+		b = c
+		fb, err = s.f(b)
+	}
+	if _, isGoalErr := err.(GoalSeekError); isGoalErr && s.calls >= s.goal.MaxIterations {
+		return 0, false, err
+	}
+	// target may not be a number everywhere, bisection gets another chance within its range
+	return 0, false, nil
+}
+
+// range where the target crosses the goal: the given one or growing around x0
+func (s *goalSeeker) bracket(x0 float64) (float64, float64, float64, float64, error) {
+	if s.goal.Low != 0 || s.goal.High != 0 {
+		lo, hi := s.goal.Low, s.goal.High
+		flo, err := s.f(lo)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		fhi, err := s.f(hi)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		if (flo > 0) == (fhi > 0) && flo != 0 && fhi != 0 {
+			return 0, 0, 0, 0, s.fail(fmt.Sprintf("target doesn't reach %s between %v and %v", s.goalText(), lo, hi))
+		}
+		return lo, hi, flo, fhi, nil
+	}
+	step := math.Max(math.Abs(x0)/2, 1)
+	for s.calls+2 <= s.goal.MaxIterations {
+		lo, hi := x0-step, x0+step
+		flo, err := s.f(lo)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		fhi, err := s.f(hi)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		if (flo > 0) != (fhi > 0) || flo == 0 || fhi == 0 {
+			return lo, hi, flo, fhi, nil
+		}
+		step *= 2
+	}
+	return 0, 0, 0, 0, s.fail(fmt.Sprintf("target doesn't reach %s within %d evaluations", s.goalText(), s.goal.MaxIterations))
+}
+
+// SeekWorkbook looks for the value of the input cell of the first sheet making the target reach the
+// goal value: secant steps from the current input first, then bisection of a range where the target
+// crosses the goal. The sheet is evaluated once per step, up to MaxIterations times.
+func SeekWorkbook(wb m.Workbook, goal GoalSeek, options ...Option) (GoalSeekResult, error) {
+	if goal.Tolerance <= 0 {
+		goal.Tolerance = 1e-9
+	}
+	if goal.MaxIterations <= 0 {
+		goal.MaxIterations = 100
+	}
+	if len(wb.Sheets) == 0 {
+		return GoalSeekResult{}, GoalSeekError{Reason: "no sheets"}
+	}
+	es := prepareWorkbook(wb, options)[0]
+	rowIdx, colIdx, err := inputPosition(es, goal.Input)
+	if err != nil {
+		return GoalSeekResult{}, GoalSeekError{Reason: err.Error()}
+	}
+	x0, ok := inputValue(es.csvCells[rowIdx][colIdx])
+	if !ok {
+		return GoalSeekResult{}, GoalSeekError{Reason: fmt.Sprintf("input %v must be a number cell", goal.Input)}
+	}
+	s := goalSeeker{
+		wb:      wb,
+		goal:    goal,
+		options: options,
+		rowIdx:  rowIdx,
+		colIdx:  colIdx,
+		values:  make(map[float64]CalculatedValue),
+	}
+
+	x, ok, err := s.secant(x0)
+	if err != nil {
+		return GoalSeekResult{}, err
+	}
+	if ok && s.inRange(x) {
+		return s.result(x), nil
+	}
+	lo, hi, flo, fhi, err := s.bracket(x0)
+	if err != nil {
+		return GoalSeekResult{}, err
+	}
+	for {
+		switch {
+		case s.found(lo, flo):
+			return s.result(lo), nil
+		case s.found(hi, fhi):
+			return s.result(hi), nil
+		case s.boolean && math.Abs(hi-lo) <= goal.Tolerance:
+			// the side giving the wanted value
+			if flo > 0 {
+				return s.result(lo), nil
+			}
+			return s.result(hi), nil
+		}
+		mid := lo + (hi-lo)/2
+		if mid == lo || mid == hi {
+			return GoalSeekResult{}, s.fail(fmt.Sprintf("target jumps over %s at input %s", s.goalText(), strconv.FormatFloat(mid, 'f', -1, 64)))
+		}
+		fmid, err := s.f(mid)
+		if err != nil {
+			return GoalSeekResult{}, err
+		}
+		if (fmid > 0) == (flo > 0) {
+			lo, flo = mid, fmid
+		} else {
+			hi, fhi = mid, fmid
+		}
+	}
+}
+
+// Seek is SeekWorkbook for a single sheet
+func Seek(cells CSVCells, goal GoalSeek, options ...Option) (GoalSeekResult, error) {
+	return SeekWorkbook(m.Workbook{Sheets: []m.Sheet{{Cells: cells}}}, goal, options...)
+}
@@ -10,7 +10,7 @@ import (
 func calcMul(lhs, rhs CalculatedValue) CalculatedValue {
 	switch l := lhs.(type) {
 	case intValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return intValue(l * r)
 		case floatValue:
@@ -20,7 +20,7 @@ func calcMul(lhs, rhs CalculatedValue) CalculatedValue {
 
 		}
 	case floatValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return floatValue(float64(l) * float64(r))
 		case floatValue:
@@ -37,11 +37,25 @@ func calcMul(lhs, rhs CalculatedValue) CalculatedValue {
 	}
 }
 
-// fixme: div by 0
+func isZero(v CalculatedValue) bool {
+	switch v := v.(type) {
+	case intValue:
+		return v == 0
+	case floatValue:
+		return v == 0
+	default:
+		return false
+	}
+}
+
+// dividing ints or floats by zero gives #DIV/0!
 func calcDiv(lhs, rhs CalculatedValue) CalculatedValue {
+	if isZero(rhs) {
+		return errDivByZero
+	}
 	switch l := lhs.(type) {
 	case intValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return intValue(l / r)
 		case floatValue:
@@ -51,7 +65,7 @@ func calcDiv(lhs, rhs CalculatedValue) CalculatedValue {
 
 		}
 	case floatValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return floatValue(float64(l) / float64(r))
 		case floatValue:
@@ -71,7 +85,7 @@ func calcDiv(lhs, rhs CalculatedValue) CalculatedValue {
 func calcAdd(lhs, rhs CalculatedValue) CalculatedValue {
 	switch l := lhs.(type) {
 	case intValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return intValue(l + r)
 		case floatValue:
@@ -81,7 +95,7 @@ func calcAdd(lhs, rhs CalculatedValue) CalculatedValue {
 
 		}
 	case floatValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return floatValue(float64(l) + float64(r))
 		case floatValue:
@@ -91,7 +105,7 @@ func calcAdd(lhs, rhs CalculatedValue) CalculatedValue {
 
 		}
 	case stringValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return stringValue(l + stringValue(strconv.Itoa(int(r))))
 		case floatValue:
@@ -109,7 +123,7 @@ func calcAdd(lhs, rhs CalculatedValue) CalculatedValue {
 func calcSub(lhs, rhs CalculatedValue) CalculatedValue {
 	switch l := lhs.(type) {
 	case intValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return intValue(l - r)
 		case floatValue:
@@ -119,7 +133,7 @@ func calcSub(lhs, rhs CalculatedValue) CalculatedValue {
 
 		}
 	case floatValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return floatValue(float64(l) - float64(r))
 		case floatValue:
@@ -138,14 +152,14 @@ func calcSub(lhs, rhs CalculatedValue) CalculatedValue {
 func bte_(lhs, rhs CalculatedValue) CalculatedValue {
 	switch l := lhs.(type) {
 	case intValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return boolValue(l <= r)
 		case floatValue:
 			return boolValue(float64(l) <= float64(r))
 		}
 	case floatValue:
-		switch r := lhs.(type) {
+		switch r := rhs.(type) {
 		case intValue:
 			return boolValue(float64(l) <= float64(r))
 		case floatValue:
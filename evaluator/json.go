@@ -0,0 +1,119 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// JSONCell is a calculated cell for JSON output
+type JSONCell struct {
+	Type    string `json:"type"`              // int, float, string, bool, multi, error or label
+	Value   any    `json:"value,omitempty"`   // elements of multi values are JSONCells too
+	Code    string `json:"code,omitempty"`    // of errors, e.g. #DIV/0!
+	Formula string `json:"formula,omitempty"` // the cell was calculated with, ^^ shows the copied formula
+	Label   string `json:"label,omitempty"`   // of the column, for cells below a label
+}
+
+// JSONSheet holds calculated cells of a sheet row by row, with arrays spilled like in text output
+type JSONSheet struct {
+	Name string       `json:"name,omitempty"`
+	Rows [][]JSONCell `json:"rows"`
+}
+
+func jsonValue(v CalculatedValue) JSONCell {
+	switch v := v.(type) {
+	case intValue:
+		return JSONCell{Type: IntType.String(), Value: int(v)}
+	case floatValue:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			// JSON has no such numbers
+			return JSONCell{Type: ErrorType.String(), Code: errNum.String()}
+		}
+		return JSONCell{Type: FloatType.String(), Value: f}
+	case boolValue:
+		return JSONCell{Type: BoolType.String(), Value: bool(v)}
+	case errorValue:
+		return JSONCell{Type: ErrorType.String(), Code: v.String()}
+	case multiValue:
+		return jsonMulti(v)
+	case spreadValue:
+		return jsonMulti(v)
+	default:
+		return JSONCell{Type: StringType.String(), Value: v.String()}
+	}
+}
+
+func jsonMulti(elems []CalculatedValue) JSONCell {
+	cells := make([]JSONCell, len(elems))
+	for i, elem := range elems {
+		cells[i] = jsonValue(elem)
+	}
+	return JSONCell{Type: MultiType.String(), Value: cells}
+}
+
+// label of the column the cell is in, defined last on a row above it
+func columnLabel(es *evalState, rowIdx, colIdx int) string {
+	if len(es.labelsOnRow) == 0 {
+		return ""
+	}
+	labels := es.labelsOnRow[clamp(rowIdx, 0, len(es.labelsOnRow)-1)]
+	res, resRowIdx := "", -1
+	for name, def := range labels {
+		// qualified names repeat the label
+		if def.colIdx != colIdx || def.rowIdx >= rowIdx || strings.Contains(name, ".") {
+			continue
+		}
+		if def.rowIdx > resRowIdx {
+			res, resRowIdx = name, def.rowIdx
+		}
+	}
+	return res
+}
+
+// cells of a calculated sheet with their formulas and labels
+func jsonSheet(es *evalState, values [][]CalculatedValue) JSONSheet {
+	rows := make([][]JSONCell, len(values))
+	for rowIdx, row := range values {
+		rows[rowIdx] = make([]JSONCell, len(row))
+		for colIdx, v := range row {
+			if isInSheet(es, rowIdx, colIdx) {
+				if label, ok := es.csvCells[rowIdx][colIdx].(m.LabelCell); ok {
+					rows[rowIdx][colIdx] = JSONCell{Type: "label", Value: label.Label}
+					continue
+				}
+			}
+			cell := jsonValue(v)
+			if isInSheet(es, rowIdx, colIdx) && es.evalCells[rowIdx][colIdx].formula != nil {
+				cell.Formula = fmt.Sprintf("%v", *es.evalCells[rowIdx][colIdx].formula)
+			}
+			cell.Label = columnLabel(es, rowIdx, colIdx)
+			rows[rowIdx][colIdx] = cell
+		}
+	}
+	return JSONSheet{Rows: rows}
+}
+
+// EvaluateJSON evaluates the sheet like Evaluate, for JSON output
+func EvaluateJSON(cells CSVCells, options ...Option) JSONSheet {
+	es := prepareState(cells, options)
+	calculateAll(&es, es.csvCells)
+	return jsonSheet(&es, sheetValues(&es))
+}
+
+// EvaluateWorkbookJSON evaluates all sheets of the workbook like EvaluateWorkbook, for JSON output
+func EvaluateWorkbookJSON(wb m.Workbook, options ...Option) []JSONSheet {
+	states := prepareWorkbook(wb, options)
+	for _, es := range states {
+		calculateAll(es, es.csvCells)
+	}
+	res := make([]JSONSheet, len(states))
+	for i, es := range states {
+		res[i] = jsonSheet(es, sheetValues(es))
+		res[i].Name = wb.Sheets[i].Name
+	}
+	return res
+}
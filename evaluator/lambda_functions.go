@@ -0,0 +1,126 @@
+package evaluator
+
+import (
+	"fmt"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// function value created by lambda(), it sees names bound where it was created
+type lambdaValue struct {
+	lambda m.Lambda
+	scope  *scope
+}
+
+func (lambdaValue) isCalculatedValue() {}
+func (v lambdaValue) String() string {
+	return v.lambda.String()
+}
+
+// each let() value is evaluated with the names bound before it
+func calcLet(es *evalState, v m.Let, rowIdx, colIdx int) CalculatedValue {
+	s := es.scope
+	for i, name := range v.Names {
+		value := calcInScope(es, s, &v.Values[i], rowIdx, colIdx)
+		s = &scope{names: map[string]CalculatedValue{name: value}, parent: s}
+	}
+	return calcInScope(es, s, &v.Body, rowIdx, colIdx)
+}
+
+func calcLambda(es *evalState, v m.Lambda) CalculatedValue {
+	return lambdaValue{
+		lambda: v,
+		scope:  es.scope,
+	}
+}
+
+// calls lambda fn with args, fname is used in error messages
+func applyLambda(call *Call, fname string, fn lambdaValue, args ...CalculatedValue) CalculatedValue {
+	if len(args) != len(fn.lambda.Params) {
+		panic(fmt.Sprintf("Function %s() expects exactly %d argument(s), got %d", fname, len(fn.lambda.Params), len(args)))
+	}
+	es := call.es
+	if es.callDepth >= maxCallDepth {
+		return errNum
+	}
+	es.callDepth++
+	defer func() { es.callDepth-- }()
+
+	params := &scope{names: make(map[string]CalculatedValue), parent: fn.scope}
+	for i, name := range fn.lambda.Params {
+		params.names[name] = args[i]
+	}
+	return calcInScope(es, params, &fn.lambda.Body, call.Row, call.Col)
+}
+
+// call of a name bound to a lambda, e.g. let(f, lambda(x, x * 2), f(3))
+func calcLambdaCall(es *evalState, v m.FunCall, value CalculatedValue, rowIdx, colIdx int) CalculatedValue {
+	fn, ok := value.(lambdaValue)
+	if !ok {
+		panic(fmt.Sprintf("Name %s is not a function", v.Name))
+	}
+	args := make([]CalculatedValue, len(v.Params))
+	for i := range v.Params {
+		args[i] = calcExpr(es, &v.Params[i], rowIdx, colIdx)
+	}
+	call := &Call{
+		Name: v.Name,
+		Row:  rowIdx,
+		Col:  colIdx,
+		es:   es,
+	}
+	return applyLambda(call, v.Name, fn, args...)
+}
+
+// map(values, lambda(x, ...)) applies lambda to each element, keeping the shape of values
+func mapValues(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	fn := args[1].(lambdaValue)
+	var apply func(v CalculatedValue) CalculatedValue
+	apply = func(v CalculatedValue) CalculatedValue {
+		mv, ok := v.(multiValue)
+		if !ok {
+			return applyLambda(call, "map", fn, v)
+		}
+		res := make(multiValue, len(mv))
+		for i, elem := range mv {
+			res[i] = apply(elem)
+		}
+		return res
+	}
+	return apply(args[0])
+}
+
+// filter(values, lambda(x, ...)) keeps elements for which lambda returns a true value
+func filter(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	fn := args[1].(lambdaValue)
+	res := make(multiValue, 0)
+	for _, v := range flattenValues(args[:1]) {
+		keep := applyLambda(call, "filter", fn, v)
+		if e, ok := keep.(errorValue); ok {
+			return e
+		}
+		if truthy("filter", keep) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// reduce(initial, values, lambda(acc, x, ...)) folds elements into an accumulated value
+func reduce(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	fn := args[2].(lambdaValue)
+	acc := args[0]
+	for _, v := range flattenValues(args[1:2]) {
+		acc = applyLambda(call, "reduce", fn, acc, v)
+	}
+	return acc
+}
@@ -0,0 +1,276 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lookup functions work on ranges (A1:C3), label blocks (@token_prices) and multi values.
+// Positions are 1-based, exact matching is the default and #N/A is returned when nothing is found.
+
+type matchMode int
+
+const (
+	exactMatch         matchMode = 0
+	exactOrNextSmaller matchMode = -1
+	exactOrNextLarger  matchMode = 1
+)
+
+// ordering of value kinds used when comparing values of different types
+func valueKind(v CalculatedValue) int {
+	switch v.(type) {
+	case intValue, floatValue:
+		return 0
+	case stringValue:
+		return 1
+	case boolValue:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// compares values for lookups: numbers numerically, text case-insensitively;
+// values of different kinds are ordered numbers < text < bools, like in other spreadsheets
+func compareValues(a, b CalculatedValue) int {
+	ka, kb := valueKind(a), valueKind(b)
+	if ka != kb {
+		return ka - kb
+	}
+	switch a := a.(type) {
+	case intValue, floatValue:
+		fa, _ := asNumber(a)
+		fb, _ := asNumber(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		default:
+			return 0
+		}
+	case stringValue:
+		return strings.Compare(strings.ToLower(string(a)), strings.ToLower(b.String()))
+	case boolValue:
+		bb := b.(boolValue)
+		switch {
+		case a == bb:
+			return 0
+		case !bool(a):
+			return -1
+		default:
+			return 1
+		}
+	default:
+		return strings.Compare(a.String(), b.String())
+	}
+}
+
+// returns 0-based index of key in values, or -1 if not found. Approximate modes pick
+// the closest value of the same kind, so the values don't need to be sorted
+func lookupIndex(key CalculatedValue, values []CalculatedValue, mode matchMode) int {
+	best := -1
+	for i, v := range values {
+		cmp := compareValues(v, key)
+		if cmp == 0 {
+			return i
+		}
+		if mode == exactMatch || valueKind(v) != valueKind(key) {
+			continue
+		}
+		if mode == exactOrNextSmaller && cmp < 0 && (best < 0 || compareValues(v, values[best]) > 0) {
+			best = i
+		}
+		if mode == exactOrNextLarger && cmp > 0 && (best < 0 || compareValues(v, values[best]) < 0) {
+			best = i
+		}
+	}
+	return best
+}
+
+// interprets value as rows of a table, a flat multi value is a single row
+func asTable(v CalculatedValue) [][]CalculatedValue {
+	mv, ok := v.(multiValue)
+	if !ok {
+		return [][]CalculatedValue{{v}}
+	}
+	rows := make([][]CalculatedValue, 0, len(mv))
+	for _, elem := range mv {
+		row, ok := elem.(multiValue)
+		if !ok {
+			return [][]CalculatedValue{mv}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func tableColumn(table [][]CalculatedValue, colIdx int) []CalculatedValue {
+	res := make([]CalculatedValue, len(table))
+	for i, row := range table {
+		if colIdx < len(row) {
+			res[i] = row[colIdx]
+		} else {
+			res[i] = stringValue("")
+		}
+	}
+	return res
+}
+
+func truthy(fname string, v CalculatedValue) bool {
+	switch v := v.(type) {
+	case boolValue:
+		return bool(v)
+	case stringValue:
+		return strings.EqualFold(string(v), "true")
+	}
+	if f, ok := asNumber(v); ok {
+		return f != 0
+	}
+	panic(fmt.Sprintf("Function %s() expects a boolean argument", fname))
+}
+
+// matching mode from optional approximate flag at position idx
+func approximateArg(fname string, args []CalculatedValue, idx int) matchMode {
+	if len(args) > idx && truthy(fname, args[idx]) {
+		return exactOrNextSmaller
+	}
+	return exactMatch
+}
+
+// vlookup(key, table, column, [approximate=false]) looks key up in the first column of table
+func vlookup(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	table := asTable(args[1])
+	col := asInt("vlookup", args[2])
+	if col < 1 {
+		return errValue
+	}
+	idx := lookupIndex(args[0], tableColumn(table, 0), approximateArg("vlookup", args, 3))
+	if idx < 0 {
+		return errNotAvailable
+	}
+	if col > len(table[idx]) {
+		return errRef
+	}
+	return table[idx][col-1]
+}
+
+// hlookup(key, table, row, [approximate=false]) looks key up in the first row of table
+func hlookup(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	table := asTable(args[1])
+	row := asInt("hlookup", args[2])
+	if row < 1 {
+		return errValue
+	}
+	if len(table) == 0 {
+		return errNotAvailable
+	}
+	idx := lookupIndex(args[0], table[0], approximateArg("hlookup", args, 3))
+	if idx < 0 {
+		return errNotAvailable
+	}
+	if row > len(table) {
+		return errRef
+	}
+	return tableColumn(table, idx)[row-1]
+}
+
+// xlookup(key, lookupValues, returnValues, [ifNotFound], [mode=0]), mode is 0 for exact match,
+// -1 for exact or next smaller and 1 for exact or next larger value. When returnValues has
+// several columns the whole matching row is returned
+func xlookup(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError([]CalculatedValue{args[0], args[1], args[2]}); ok {
+		return e
+	}
+	mode := exactMatch
+	if len(args) > 4 {
+		mode = matchMode(asInt("xlookup", args[4]))
+		if mode < exactOrNextSmaller || mode > exactOrNextLarger {
+			return errValue
+		}
+	}
+	idx := lookupIndex(args[0], flattenValues([]CalculatedValue{args[1]}), mode)
+	if idx < 0 {
+		if len(args) > 3 {
+			return args[3]
+		}
+		return errNotAvailable
+	}
+	returnTable := asTable(args[2])
+	if len(returnTable) == 1 {
+		// a single row of values
+		if idx >= len(returnTable[0]) {
+			return errRef
+		}
+		return returnTable[0][idx]
+	}
+	if idx >= len(returnTable) {
+		return errRef
+	}
+	if len(returnTable[idx]) == 1 {
+		return returnTable[idx][0]
+	}
+	return multiValue(returnTable[idx])
+}
+
+// index(table, row, [column]), row or column 0 selects the whole column or row;
+// for a single row or column the second argument is the position within it
+func index(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	table := asTable(args[0])
+	row := asInt("index", args[1])
+	col := optionalInt("index", args, 2, 1)
+	if len(args) == 2 && len(table) == 1 {
+		// position within a single row
+		row, col = 1, row
+	}
+	if row < 0 || col < 0 || row > len(table) || len(table) == 0 {
+		return errRef
+	}
+	switch {
+	case row == 0 && col == 0:
+		return args[0]
+	case row == 0:
+		if col > len(table[0]) {
+			return errRef
+		}
+		return multiValue(tableColumn(table, col-1))
+	case col == 0:
+		return multiValue(table[row-1])
+	}
+	if col > len(table[row-1]) {
+		return errRef
+	}
+	return table[row-1][col-1]
+}
+
+// match(key, values, [type=0]) returns 1-based position of key; type 0 is exact match,
+// 1 finds largest value not greater than key and -1 smallest value not less than key
+func match(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	mode := exactMatch
+	switch optionalInt("match", args, 2, 0) {
+	case 0:
+	case 1:
+		mode = exactOrNextSmaller
+	case -1:
+		mode = exactOrNextLarger
+	default:
+		return errValue
+	}
+	idx := lookupIndex(args[0], flattenValues([]CalculatedValue{args[1]}), mode)
+	if idx < 0 {
+		return errNotAvailable
+	}
+	return intValue(idx + 1)
+}
@@ -0,0 +1,226 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+)
+
+// converts function argument to a number following sum() coercion rules
+func numberArg(fname string, v CalculatedValue) float64 {
+	f, ok := asNumber(v)
+	if !ok {
+		panic(fmt.Sprintf("Couldn't convert %s() argument to a number", fname))
+	}
+	return f
+}
+
+// keeps ints, other numbers become floats
+func numericValue(fname string, v CalculatedValue) CalculatedValue {
+	if i, ok := v.(intValue); ok {
+		return i
+	}
+	return floatValue(numberArg(fname, v))
+}
+
+// number of digits to round to, optional argument at position idx
+func digitsArg(fname string, args []CalculatedValue, idx int) int {
+	return optionalInt(fname, args, idx, 0)
+}
+
+// rounds x to given number of decimal digits (negative digits round to tens, hundreds...) using roundFn
+func roundWith(x float64, digits int, roundFn func(float64) float64) float64 {
+	scale := math.Pow(10, float64(digits))
+	return roundFn(x*scale) / scale
+}
+
+func awayFromZero(x float64) float64 {
+	if x < 0 {
+		return math.Floor(x)
+	}
+	return math.Ceil(x)
+}
+
+// unary float function with error value propagation
+func mathFn1(fname string, args []CalculatedValue, f func(float64) CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	return f(numberArg(fname, args[0]))
+}
+
+// round(x, [digits=0]) rounds half away from zero
+func round(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	return floatValue(roundWith(numberArg("round", args[0]), digitsArg("round", args, 1), math.Round))
+}
+
+// roundup(x, [digits=0]) rounds away from zero
+func roundup(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	return floatValue(roundWith(numberArg("roundup", args[0]), digitsArg("roundup", args, 1), awayFromZero))
+}
+
+// rounddown(x, [digits=0]) rounds towards zero
+func rounddown(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	return floatValue(roundWith(numberArg("rounddown", args[0]), digitsArg("rounddown", args, 1), math.Trunc))
+}
+
+// trunc(x, [digits=0]) drops digits past the given one
+func trunc(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	return floatValue(roundWith(numberArg("trunc", args[0]), digitsArg("trunc", args, 1), math.Trunc))
+}
+
+// rounds x to a multiple of optional significance (default 1) using roundFn
+func roundToMultiple(fname string, args []CalculatedValue, roundFn func(float64) float64) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	x := numberArg(fname, args[0])
+	significance := 1.0
+	if len(args) > 1 {
+		significance = numberArg(fname, args[1])
+	}
+	if significance == 0 {
+		return errDivByZero
+	}
+	return floatValue(roundFn(x/significance) * significance)
+}
+
+// floor(x, [significance=1])
+func floor(call *Call, args []CalculatedValue) CalculatedValue {
+	return roundToMultiple("floor", args, math.Floor)
+}
+
+// ceil(x, [significance=1])
+func ceil(call *Call, args []CalculatedValue) CalculatedValue {
+	return roundToMultiple("ceil", args, math.Ceil)
+}
+
+// int(x) rounds down to the nearest integer
+func toInt(call *Call, args []CalculatedValue) CalculatedValue {
+	return mathFn1("int", args, func(x float64) CalculatedValue {
+		return intValue(math.Floor(x))
+	})
+}
+
+// abs keeps int values as ints
+func abs(call *Call, args []CalculatedValue) CalculatedValue {
+	if i, ok := args[0].(intValue); ok {
+		if i < 0 {
+			return -i
+		}
+		return i
+	}
+	return mathFn1("abs", args, func(x float64) CalculatedValue {
+		return floatValue(math.Abs(x))
+	})
+}
+
+// sign(x) is -1, 0 or 1
+func sign(call *Call, args []CalculatedValue) CalculatedValue {
+	return mathFn1("sign", args, func(x float64) CalculatedValue {
+		switch {
+		case x > 0:
+			return intValue(1)
+		case x < 0:
+			return intValue(-1)
+		default:
+			return intValue(0)
+		}
+	})
+}
+
+// mod(x, divisor), result has the sign of divisor like in other spreadsheets
+func mod(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	x, divisor := numberArg("mod", args[0]), numberArg("mod", args[1])
+	if divisor == 0 {
+		return errDivByZero
+	}
+	res := x - divisor*math.Floor(x/divisor)
+	_, xIsInt := args[0].(intValue)
+	_, divisorIsInt := args[1].(intValue)
+	if xIsInt && divisorIsInt {
+		return intValue(res)
+	}
+	return floatValue(res)
+}
+
+// pow(base, exponent)
+func pow(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	res := math.Pow(numberArg("pow", args[0]), numberArg("pow", args[1]))
+	if math.IsNaN(res) || math.IsInf(res, 0) {
+		return errNum
+	}
+	return floatValue(res)
+}
+
+func sqrt(call *Call, args []CalculatedValue) CalculatedValue {
+	return mathFn1("sqrt", args, func(x float64) CalculatedValue {
+		if x < 0 {
+			return errNum
+		}
+		return floatValue(math.Sqrt(x))
+	})
+}
+
+func exp(call *Call, args []CalculatedValue) CalculatedValue {
+	return mathFn1("exp", args, func(x float64) CalculatedValue {
+		return floatValue(math.Exp(x))
+	})
+}
+
+func ln(call *Call, args []CalculatedValue) CalculatedValue {
+	return mathFn1("ln", args, func(x float64) CalculatedValue {
+		if x <= 0 {
+			return errNum
+		}
+		return floatValue(math.Log(x))
+	})
+}
+
+func log10(call *Call, args []CalculatedValue) CalculatedValue {
+	return mathFn1("log10", args, func(x float64) CalculatedValue {
+		if x <= 0 {
+			return errNum
+		}
+		return floatValue(math.Log10(x))
+	})
+}
+
+func pi(call *Call, args []CalculatedValue) CalculatedValue {
+	return floatValue(math.Pi)
+}
+
+// rand() returns a float in [0, 1), see WithSeed for reproducible results
+func random(call *Call, args []CalculatedValue) CalculatedValue {
+	return floatValue(call.es.rng.Float64())
+}
+
+// randbetween(low, high) returns an int in [low, high]
+func randbetween(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	low := int(math.Ceil(numberArg("randbetween", args[0])))
+	high := int(math.Floor(numberArg("randbetween", args[1])))
+	if low > high {
+		return errNum
+	}
+	return intValue(low + call.es.rng.Intn(high-low+1))
+}
@@ -0,0 +1,31 @@
+package evaluator
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Option customizes a single evaluation
+type Option func(es *evalState)
+
+// WithSeed seeds the random source used by rand() and randbetween(), making evaluation reproducible
+func WithSeed(seed int64) Option {
+	return func(es *evalState) {
+		es.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+func applyOptions(es *evalState, options []Option) {
+	es.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	es.functions = supportedFunctions
+	for _, option := range options {
+		option(es)
+	}
+}
+
+// WithFunctions makes functions of the registry (and its parents) available to formulas
+func WithFunctions(registry *FunctionRegistry) Option {
+	return func(es *evalState) {
+		es.functions = registry
+	}
+}
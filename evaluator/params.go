@@ -0,0 +1,115 @@
+package evaluator
+
+import (
+	"fmt"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// value of a literal cell, e.g. a parameter given for the evaluation
+func paramValue(cell m.Cell) CalculatedValue {
+	switch v := cell.(type) {
+	case m.IntCell:
+		return intValue(v.Value)
+	case m.FloatCell:
+		return floatValue(v.Value)
+	case m.StringCell:
+		return stringValue(v.Value)
+	default:
+		panic(fmt.Sprintf("Parameter must be a literal value, got %T", cell))
+	}
+}
+
+// WithParams makes named values available to formulas as $name or param("name"), so the same sheet
+// can be evaluated for different scenarios; values are typed like cell literals, see parser.ParseValue
+func WithParams(params map[string]m.Cell) Option {
+	return func(es *evalState) {
+		es.params = make(map[string]CalculatedValue, len(params))
+		for name, cell := range params {
+			es.params[name] = paramValue(cell)
+		}
+	}
+}
+
+// EvaluateWithParams evaluates the sheet with named parameters, see WithParams
+func EvaluateWithParams(cells CSVCells, params map[string]m.Cell, options ...Option) [][]CalculatedValue {
+	return Evaluate(cells, append(options, WithParams(params))...)
+}
+
+// e.g. $fee, #N/A when the parameter is not given
+func calcParamRef(es *evalState, v m.ParamRef) CalculatedValue {
+	value, found := es.params[v.Name]
+	if !found {
+		return errNotAvailable
+	}
+	return value
+}
+
+func param(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	return calcParamRef(call.es, m.ParamRef{Name: args[0].String()})
+}
+
+// reports parameters not given for the evaluation
+func checkParamRefs(es *evalState, formula m.Expr) []string {
+	problems := make([]string, 0)
+	m.Walk(formula, func(expr m.Expr) bool {
+		if v, ok := expr.(m.ParamRef); ok {
+			if _, found := es.params[v.Name]; !found {
+				problems = append(problems, fmt.Sprintf("unknown parameter %v", v))
+			}
+		}
+		return true
+	})
+	return problems
+}
+
+// UnusedParams lists the names no formula of the workbook nor output refers to as $name or
+// param("name"); a param() call with a calculated name might use any parameter, then none is listed
+func UnusedParams(wb m.Workbook, outputs []m.Expr, names []string) []string {
+	used := make(map[string]bool)
+	anyUsed := false
+	visit := func(expr m.Expr) bool {
+		switch v := expr.(type) {
+		case m.ParamRef:
+			used[v.Name] = true
+		case m.FunCall:
+			if v.Name != "param" || len(v.Params) == 0 {
+				break
+			}
+			if name, ok := v.Params[0].(m.StringLit); ok {
+				used[string(name)] = true
+			} else {
+				anyUsed = true
+			}
+		}
+		return true
+	}
+	for _, sheet := range wb.Sheets {
+		for _, row := range sheet.Cells {
+			for _, cell := range row {
+				switch v := cell.(type) {
+				case m.FormulaCell:
+					m.Walk(v.Formula, visit)
+				case m.FunctionDefCell:
+					m.Walk(v.Body, visit)
+				}
+			}
+		}
+	}
+	for _, output := range outputs {
+		m.Walk(output, visit)
+	}
+	unused := make([]string, 0)
+	if anyUsed {
+		return unused
+	}
+	for _, name := range names {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	return unused
+}
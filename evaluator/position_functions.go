@@ -0,0 +1,63 @@
+package evaluator
+
+import (
+	m "pasza.org/sr-challenge/model"
+)
+
+// functions of the position of the formula cell, they give the same result however the formula
+// got to the cell: written out, copied with ^^ or filled with =^^*
+
+func row(call *Call, args []CalculatedValue) CalculatedValue {
+	return intValue(call.Row + 1)
+}
+
+func col(call *Call, args []CalculatedValue) CalculatedValue {
+	return intValue(call.Col + 1)
+}
+
+// rowsSinceLabel("fee") is 1 on the row right below the !fee label, #REF! for unknown labels
+func rowsSinceLabel(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	label, found := call.es.labelsOnRow[call.Row][args[0].String()]
+	if !found {
+		return errRef
+	}
+	return intValue(call.Row - label.rowIdx)
+}
+
+// rows between the formula cell and the closest row with labels above it, 0 right below labels
+func rowInBlock(es *evalState, rowIdx int) int {
+	for labelRowIdx := rowIdx - 1; labelRowIdx >= 0; labelRowIdx-- {
+		if hasLabelCell(es, labelRowIdx) {
+			return rowIdx - labelRowIdx - 1
+		}
+	}
+	return rowIdx
+}
+
+// seq(start, [step=1]) numbers rows of a block: start right below labels, start+step below it...
+func seq(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	start := numericValue("seq", args[0])
+	var step CalculatedValue = intValue(1)
+	if len(args) > 1 {
+		step = numericValue("seq", args[1])
+	}
+	n := intValue(rowInBlock(call.es, call.Row))
+	return calcBinaryOp(m.ADD, start, calcBinaryOp(m.MUL, step, n))
+}
+
+// incFrom(start, [step=1]) counts copies of the formula: ^^ and =^^* copy it from the cell above.
+// C^ repeats the value above instead of copying the formula, so it doesn't count.
+func incFrom(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	ec := call.es.evalCells[call.Row][call.Col]
+	step := optionalInt("incFrom", args, 1, 1)
+	return intValue(asInt("incFrom", args[0]) + step*ec.copyCount)
+}
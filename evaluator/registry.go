@@ -0,0 +1,235 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"unicode"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// Variadic as FunctionSpec.MaxArgs means there is no upper limit of arguments
+const Variadic = -1
+
+// ParamType declares what kind of values a function parameter accepts.
+// Error values are accepted by every parameter type, so functions can propagate them.
+type ParamType int
+
+const (
+	AnyParam    ParamType = iota
+	NumberParam           // int, float or numeric string
+	IntParam              // number without a fractional part, e.g. 2, 2.0 or "2"
+	StringParam           // text value
+	BoolParam             // bool, number or "true"/"false" text
+	MultiParam            // multi value, e.g. a range or split() result
+	LambdaParam           // function value created with lambda()
+)
+
+func (t ParamType) String() string {
+	switch t {
+	case AnyParam:
+		return "any"
+	case NumberParam:
+		return "number"
+	case IntParam:
+		return "int"
+	case StringParam:
+		return "string"
+	case BoolParam:
+		return "bool"
+	case MultiParam:
+		return "multi"
+	case LambdaParam:
+		return "lambda"
+	default:
+		return "?"
+	}
+}
+
+func (t ParamType) accepts(v CalculatedValue) bool {
+	if _, ok := v.(errorValue); ok {
+		return true
+	}
+	switch t {
+	case NumberParam:
+		_, ok := asNumber(v)
+		return ok
+	case IntParam:
+		f, ok := asNumber(v)
+		return ok && f == math.Trunc(f)
+	case StringParam:
+		_, ok := v.(stringValue)
+		return ok
+	case BoolParam:
+		switch v := v.(type) {
+		case boolValue, intValue, floatValue:
+			return true
+		case stringValue:
+			return v == "true" || v == "false"
+		}
+		return false
+	case MultiParam:
+		_, ok := v.(multiValue)
+		return ok
+	case LambdaParam:
+		_, ok := v.(lambdaValue)
+		return ok
+	default:
+		return true
+	}
+}
+
+// Call describes the cell a function is evaluated for
+type Call struct {
+	Name string
+	Row  int // 0-based row of the calling cell
+	Col  int // 0-based column of the calling cell
+	es   *evalState
+}
+
+// Eval evaluates expression in the context of the calling cell, used by lazy functions
+func (c *Call) Eval(expr m.Expr) CalculatedValue {
+	return calcExpr(c.es, &expr, c.Row, c.Col)
+}
+
+// Function gets arguments evaluated, with spread() arguments already expanded
+type Function func(call *Call, args []CalculatedValue) CalculatedValue
+
+// LazyFunction gets argument expressions and evaluates them on demand with Call.Eval
+type LazyFunction func(call *Call, args []m.Expr) CalculatedValue
+
+// FunctionSpec declares a function signature and implementation
+type FunctionSpec struct {
+	MinArgs int
+	MaxArgs int // or Variadic
+	// CheckArgCount reports a problem with the number of arguments that MinArgs and MaxArgs can't
+	// express, e.g. arguments coming in pairs, or "" when the count is fine; checked before evaluation
+	CheckArgCount func(argc int) string
+	// Params lists parameter types by position, the last one applies to all remaining
+	// arguments; no params means any values are accepted
+	Params  []ParamType
+	Returns ValueType
+	// ElementWise functions are called for each element when the first argument is a multi
+	// value, with the other arguments unchanged, and return a multi value of the results
+	ElementWise bool
+	Lazy        bool // use LazyFn instead of Fn
+	Fn          Function
+	LazyFn      LazyFunction
+	Doc         string
+}
+
+// ParamType of the argument at position idx
+func (spec FunctionSpec) ParamType(idx int) ParamType {
+	if len(spec.Params) == 0 {
+		return AnyParam
+	}
+	if idx >= len(spec.Params) {
+		return spec.Params[len(spec.Params)-1]
+	}
+	return spec.Params[idx]
+}
+
+// AcceptsArgCount checks argument count against MinArgs and MaxArgs
+func (spec FunctionSpec) AcceptsArgCount(argc int) bool {
+	return argc >= spec.MinArgs && (spec.MaxArgs == Variadic || argc <= spec.MaxArgs)
+}
+
+func (spec FunctionSpec) arityString() string {
+	switch {
+	case spec.MaxArgs == Variadic:
+		return fmt.Sprintf("at least %d argument(s)", spec.MinArgs)
+	case spec.MinArgs == spec.MaxArgs:
+		return fmt.Sprintf("exactly %d argument(s)", spec.MinArgs)
+	default:
+		return fmt.Sprintf("%d to %d arguments", spec.MinArgs, spec.MaxArgs)
+	}
+}
+
+// validates argument count and types, panics like the functions themselves do
+func (spec FunctionSpec) validate(name string, args []CalculatedValue) {
+	if !spec.AcceptsArgCount(len(args)) {
+		panic(fmt.Sprintf("Function %s() expects %s, got %d", name, spec.arityString(), len(args)))
+	}
+	for i, arg := range args {
+		if t := spec.ParamType(i); !t.accepts(arg) {
+			panic(fmt.Sprintf("Function %s() argument %d must be of %v type, got %q", name, i+1, t, arg))
+		}
+	}
+}
+
+// FunctionRegistry holds functions available to formulas. Functions not found in a registry
+// are looked up in its parent, registries created with NewFunctionRegistry fall back to builtins.
+type FunctionRegistry struct {
+	parent    *FunctionRegistry
+	functions map[string]FunctionSpec
+}
+
+// NewFunctionRegistry creates an empty registry on top of builtin functions, see WithFunctions
+func NewFunctionRegistry() *FunctionRegistry {
+	return newFunctionRegistry(supportedFunctions)
+}
+
+func newFunctionRegistry(parent *FunctionRegistry) *FunctionRegistry {
+	return &FunctionRegistry{
+		parent:    parent,
+		functions: make(map[string]FunctionSpec),
+	}
+}
+
+// name must be callable from a formula: a letter followed by letters or digits
+func isValidFunctionName(name string) bool {
+	for i, r := range name {
+		if !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Register adds function to the registry, replacing any function of the same name
+func (r *FunctionRegistry) Register(name string, spec FunctionSpec) error {
+	if !isValidFunctionName(name) {
+		return fmt.Errorf("invalid function name %q", name)
+	}
+	if spec.Lazy && spec.LazyFn == nil || !spec.Lazy && spec.Fn == nil {
+		return fmt.Errorf("function %s has no implementation", name)
+	}
+	if spec.MinArgs < 0 || spec.MaxArgs != Variadic && spec.MaxArgs < spec.MinArgs {
+		return fmt.Errorf("function %s has invalid argument count limits", name)
+	}
+	r.functions[name] = spec
+	return nil
+}
+
+func (r *FunctionRegistry) mustRegister(name string, spec FunctionSpec) {
+	if err := r.Register(name, spec); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup finds function by name, including parent registries
+func (r *FunctionRegistry) Lookup(name string) (FunctionSpec, bool) {
+	for ; r != nil; r = r.parent {
+		if spec, ok := r.functions[name]; ok {
+			return spec, true
+		}
+	}
+	return FunctionSpec{}, false
+}
+
+// Names of all available functions, sorted
+func (r *FunctionRegistry) Names() []string {
+	seen := make(map[string]bool)
+	res := make([]string, 0)
+	for ; r != nil; r = r.parent {
+		for name := range r.functions {
+			if !seen[name] {
+				seen[name] = true
+				res = append(res, name)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,81 @@
+package evaluator
+
+import (
+	"fmt"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// maximum nesting of sheet function calls, deeper calls evaluate to #NUM!
+const maxCallDepth = 100
+
+// names bound while evaluating an expression, e.g. parameters of a sheet function
+type scope struct {
+	names  map[string]CalculatedValue
+	parent *scope
+}
+
+func (s *scope) lookup(name string) (CalculatedValue, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.names[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func calcLocalName(es *evalState, v m.LocalName) CalculatedValue {
+	value, ok := es.scope.lookup(v.Name)
+	if !ok {
+		panic("Unknown name: " + v.Name)
+	}
+	return value
+}
+
+// evaluates expr with given scope, restoring the current one afterwards
+func calcInScope(es *evalState, s *scope, expr *m.Expr, rowIdx, colIdx int) CalculatedValue {
+	saved := es.scope
+	es.scope = s
+	defer func() { es.scope = saved }()
+	return calcExpr(es, expr, rowIdx, colIdx)
+}
+
+// function defined in a !def row, its body is evaluated at the position of the calling cell
+func sheetFunction(def m.FunctionDefCell) FunctionSpec {
+	return FunctionSpec{
+		MinArgs: len(def.Params),
+		MaxArgs: len(def.Params),
+		Fn: func(call *Call, args []CalculatedValue) CalculatedValue {
+			es := call.es
+			if es.callDepth >= maxCallDepth {
+				return errNum
+			}
+			es.callDepth++
+			defer func() { es.callDepth-- }()
+
+			params := &scope{names: make(map[string]CalculatedValue)}
+			for i, name := range def.Params {
+				params.names[name] = args[i]
+			}
+			return calcInScope(es, params, &def.Body, call.Row, call.Col)
+		},
+		Doc: def.String(),
+	}
+}
+
+// registers functions defined in the sheet on top of the functions available so far
+func registerSheetFunctions(es *evalState) {
+	registry := newFunctionRegistry(es.functions)
+	for _, row := range es.csvCells {
+		for _, cell := range row {
+			def, ok := cell.(m.FunctionDefCell)
+			if !ok {
+				continue
+			}
+			if err := registry.Register(def.Name, sheetFunction(def)); err != nil {
+				panic(fmt.Sprintf("Cannot register function %s: %v", def.Name, err))
+			}
+		}
+	}
+	es.functions = registry
+}
@@ -0,0 +1,227 @@
+package evaluator
+
+import (
+	m "pasza.org/sr-challenge/model"
+)
+
+// Formulas evaluating to arrays spill into neighbouring cells of the result, like dynamic arrays
+// in other spreadsheets: a flat array fills cells to the right, rows of a 2D array fill cells below.
+// Cells the array spills into must be blank and not taken by an array of an earlier formula cell
+// (in row order), otherwise the formula cell gets #SPILL!. Arrays spill as soon as their formula
+// cell is calculated, so references see the spilled values: next to ={1, 2, 3} in A1, B1 is 2 and
+// A1 is 1, while A1# is the whole array. Before a blank cell is read, formula cells above and to
+// the left of it are calculated, as they may spill into it; such a probing calculation is abandoned
+// when it reaches a cell being calculated, which would otherwise give a #CYCLE! that depends on the
+// order cells are calculated in.
+
+// WithoutSpill keeps arrays in formula cells instead of spilling them into neighbouring cells
+func WithoutSpill() Option {
+	return func(es *evalState) {
+		es.noSpill = true
+	}
+}
+
+// rows of cells an array spills into, nil for values that don't spill
+func spillTable(v CalculatedValue) [][]CalculatedValue {
+	mv, ok := v.(multiValue)
+	if !ok || len(mv) == 0 {
+		return nil
+	}
+	return asTable(mv)
+}
+
+func isBlankCell(cells CSVCells, rowIdx, colIdx int) bool {
+	if rowIdx >= len(cells) || colIdx >= len(cells[rowIdx]) {
+		return true
+	}
+	s, ok := cells[rowIdx][colIdx].(m.StringCell)
+	return ok && s.Value == ""
+}
+
+// whether cells of the sheet leave room for table spilled from the given cell
+func canSpill(cells CSVCells, rowIdx, colIdx int, table [][]CalculatedValue) bool {
+	for r, row := range table {
+		for c := range row {
+			if (r != 0 || c != 0) && !isBlankCell(cells, rowIdx+r, colIdx+c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// e.g. A2#, the whole array spilled from A2
+func calcSpillRef(es *evalState, v m.SpillRef, rowIdx, colIdx int) CalculatedValue {
+	targetRowIdx, targetColIdx := v.Anchor.Row-1, colNameToIdx(v.Anchor.Col)
+	if !isInSheet(es, targetRowIdx, targetColIdx) {
+		return errRef
+	}
+	if e, ok := getTargetValue(es, targetRowIdx, targetColIdx).(errorValue); ok {
+		return e
+	}
+	value := es.evalCells[targetRowIdx][targetColIdx].value
+	table := spillTable(value)
+	if table == nil {
+		return errRef
+	}
+	if es.noSpill && !canSpill(es.csvCells, targetRowIdx, targetColIdx, table) {
+		return errSpill
+	}
+	if es.spillBlocked[[2]int{targetRowIdx, targetColIdx}] {
+		return errSpill
+	}
+	return value
+}
+
+// sets value at given position, growing the grid with blank cells
+func setGridValue(grid [][]CalculatedValue, rowIdx, colIdx int, v CalculatedValue) [][]CalculatedValue {
+	for len(grid) <= rowIdx {
+		grid = append(grid, make([]CalculatedValue, 0))
+	}
+	for len(grid[rowIdx]) <= colIdx {
+		grid[rowIdx] = append(grid[rowIdx], stringValue(""))
+	}
+	grid[rowIdx][colIdx] = v
+	return grid
+}
+
+// cells being calculated, innermost last, across sheets of a workbook
+type calcStack struct {
+	cells   []*evalCell
+	probe   int         // cells on the stack when the innermost probe started, -1 when not probing
+	pending []spillArea // arrays waiting for earlier formula cells before spilling
+}
+
+// cells an array spills into, depth is the size of the stack when it started spilling
+type spillArea struct {
+	rowIdx, colIdx, rows, cols, depth int
+}
+
+func (a spillArea) covers(rowIdx, colIdx int) bool {
+	return rowIdx >= a.rowIdx && rowIdx < a.rowIdx+a.rows && colIdx >= a.colIdx && colIdx < a.colIdx+a.cols &&
+		(rowIdx != a.rowIdx || colIdx != a.colIdx)
+}
+
+func (s *calcStack) push(ec *evalCell) {
+	s.cells = append(s.cells, ec)
+	ec.depth = len(s.cells)
+}
+
+func (s *calcStack) pop() {
+	s.cells = s.cells[:len(s.cells)-1]
+}
+
+// reaching a cell in progress abandons the innermost probe when the cell was in progress before it
+type probeAbandoned struct{}
+
+// value for a reference to a cell in progress at the given depth of the stack
+func cycle(es *evalState, depth int) CalculatedValue {
+	if es.stack.probe >= 0 && depth <= es.stack.probe {
+		panic(probeAbandoned{})
+	}
+	return errCycle
+}
+
+// calculates a cell that may spill an array, or leaves it as it was when that needs a cell being
+// calculated
+func probe(es *evalState, rowIdx, colIdx int) {
+	s := es.stack
+	savedProbe, savedDepth, savedPending := s.probe, len(s.cells), len(s.pending)
+	savedCallDepth := es.callDepth
+	s.probe = savedDepth
+	defer func() {
+		s.probe = savedProbe
+		r := recover()
+		if r == nil {
+			return
+		}
+		if _, ok := r.(probeAbandoned); !ok {
+			panic(r)
+		}
+		for _, ec := range s.cells[savedDepth:] {
+			*ec = evalCell{copyCount: ec.copyCount}
+		}
+		s.cells, s.pending = s.cells[:savedDepth], s.pending[:savedPending]
+		es.callDepth = savedCallDepth
+	}()
+	calcCell(es, rowIdx, colIdx)
+}
+
+// calculates formula cells before the given position in row order that are above and to the left
+// of (lastRowIdx, lastColIdx), the ones that could spill into the cells up to it
+func probeFormulaCells(es *evalState, rowIdx, colIdx, lastRowIdx, lastColIdx int) {
+	for _, pos := range es.formulaCells {
+		if pos[0] > rowIdx || (pos[0] == rowIdx && pos[1] >= colIdx) {
+			break
+		}
+		ec := &es.evalCells[pos[0]][pos[1]]
+		if pos[0] <= lastRowIdx && pos[1] <= lastColIdx && !ec.done && !ec.inProgress {
+			probe(es, pos[0], pos[1])
+		}
+	}
+}
+
+// spills the array calculated in the formula cell, after arrays of formula cells before it
+func spill(es *evalState, rowIdx, colIdx int, table [][]CalculatedValue) {
+	area := spillArea{rowIdx: rowIdx, colIdx: colIdx, rows: len(table), depth: len(es.stack.cells)}
+	for _, row := range table {
+		if len(row) > area.cols {
+			area.cols = len(row)
+		}
+	}
+	es.stack.pending = append(es.stack.pending, area)
+	probeFormulaCells(es, rowIdx, colIdx, rowIdx+area.rows-1, colIdx+area.cols-1)
+	es.stack.pending = es.stack.pending[:len(es.stack.pending)-1]
+
+	for r, row := range table {
+		for c := range row {
+			_, taken := es.spilled[[2]int{rowIdx + r, colIdx + c}]
+			if (r != 0 || c != 0) && (taken || !isBlankCell(es.csvCells, rowIdx+r, colIdx+c)) {
+				es.spillBlocked[[2]int{rowIdx, colIdx}] = true
+				return
+			}
+		}
+	}
+	// the formula cell itself shows the first element when the sheet is written
+	for r, row := range table {
+		for c, elem := range row {
+			es.spilled[[2]int{rowIdx + r, colIdx + c}] = elem
+		}
+	}
+}
+
+// value an array spilled into the blank cell, after calculating formula cells that could spill
+// into it
+func spilledValue(es *evalState, rowIdx, colIdx int) (CalculatedValue, bool) {
+	if es.noSpill || rowIdx < 0 || colIdx < 0 || !isBlankCell(es.csvCells, rowIdx, colIdx) {
+		return nil, false
+	}
+	for _, area := range es.stack.pending {
+		if area.covers(rowIdx, colIdx) {
+			return cycle(es, area.depth), true
+		}
+	}
+	probeFormulaCells(es, rowIdx, colIdx+1, rowIdx, colIdx)
+	v, ok := es.spilled[[2]int{rowIdx, colIdx}]
+	return v, ok
+}
+
+// value of a cell outside of the sheet's rows, given only when an array spilled into it
+func outsideValue(es *evalState, rowIdx, colIdx int, missing CalculatedValue) CalculatedValue {
+	if v, ok := spilledValue(es, rowIdx, colIdx); ok {
+		return v
+	}
+	return missing
+}
+
+// calculated values with spilled arrays added, formula cells with arrays that couldn't spill get
+// #SPILL!
+func withSpilledArrays(es *evalState, values [][]CalculatedValue) [][]CalculatedValue {
+	for pos := range es.spillBlocked {
+		values[pos[0]][pos[1]] = errSpill
+	}
+	for pos, v := range es.spilled {
+		values = setGridValue(values, pos[0], pos[1], v)
+	}
+	return values
+}
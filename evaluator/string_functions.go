@@ -0,0 +1,313 @@
+package evaluator
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// String functions work on runes, not bytes. Positions are 1-based like in other spreadsheets.
+// Functions taking a single text argument are applied element-wise when given a multi value.
+
+// returns first error value among arguments, if any
+func firstError(args []CalculatedValue) (errorValue, bool) {
+	for _, arg := range args {
+		if e, ok := arg.(errorValue); ok {
+			return e, true
+		}
+	}
+	return "", false
+}
+
+func asString(v CalculatedValue) string {
+	return v.String()
+}
+
+func asInt(fname string, v CalculatedValue) int {
+	switch v := v.(type) {
+	case intValue:
+		return int(v)
+	case floatValue:
+		return int(v)
+	}
+	if f, ok := asNumber(v); ok {
+		return int(f)
+	}
+	panic(fmt.Sprintf("Function %s() expects an integer argument", fname))
+}
+
+// applies f to the text of v, or to each element if v is a multi value
+func mapText(v CalculatedValue, f func(string) CalculatedValue) CalculatedValue {
+	switch v := v.(type) {
+	case multiValue:
+		res := make(multiValue, len(v))
+		for i, elem := range v {
+			res[i] = mapText(elem, f)
+		}
+		return res
+	case errorValue:
+		return v
+	default:
+		return f(asString(v))
+	}
+}
+
+// optional integer argument at position idx
+func optionalInt(fname string, args []CalculatedValue, idx int, def int) int {
+	if len(args) > idx {
+		return asInt(fname, args[idx])
+	}
+	return def
+}
+
+// rune based substring, start is 0-based, out of range bounds are clamped
+func substr(s string, start, n int) string {
+	runes := []rune(s)
+	if start < 0 {
+		start = 0
+	}
+	if start > len(runes) {
+		start = len(runes)
+	}
+	end := start + n
+	if n < 0 || end > len(runes) {
+		end = len(runes)
+	}
+	return string(runes[start:end])
+}
+
+func upper(call *Call, args []CalculatedValue) CalculatedValue {
+	return mapText(args[0], func(s string) CalculatedValue {
+		return stringValue(strings.ToUpper(s))
+	})
+}
+
+func lower(call *Call, args []CalculatedValue) CalculatedValue {
+	return mapText(args[0], func(s string) CalculatedValue {
+		return stringValue(strings.ToLower(s))
+	})
+}
+
+func trim(call *Call, args []CalculatedValue) CalculatedValue {
+	return mapText(args[0], func(s string) CalculatedValue {
+		return stringValue(strings.TrimSpace(s))
+	})
+}
+
+// len(value) of an array is its number of elements, it isn't applied to each element like other text
+// functions; map(arr, lambda(s, len(s))) gives lengths of the elements
+func length(call *Call, args []CalculatedValue) CalculatedValue {
+	if mv, ok := args[0].(multiValue); ok {
+		return intValue(len(mv))
+	}
+	return mapText(args[0], func(s string) CalculatedValue {
+		return intValue(utf8.RuneCountInString(s))
+	})
+}
+
+// left(text, [count=1])
+func left(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	n := optionalInt("left", args, 1, 1)
+	if n < 0 {
+		return errValue
+	}
+	return mapText(args[0], func(s string) CalculatedValue {
+		return stringValue(substr(s, 0, n))
+	})
+}
+
+// right(text, [count=1])
+func right(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	n := optionalInt("right", args, 1, 1)
+	if n < 0 {
+		return errValue
+	}
+	return mapText(args[0], func(s string) CalculatedValue {
+		runeCount := utf8.RuneCountInString(s)
+		return stringValue(substr(s, runeCount-n, n))
+	})
+}
+
+// mid(text, start, count)
+func mid(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	start, n := asInt("mid", args[1]), asInt("mid", args[2])
+	if start < 1 || n < 0 {
+		return errValue
+	}
+	return mapText(args[0], func(s string) CalculatedValue {
+		return stringValue(substr(s, start-1, n))
+	})
+}
+
+// find(needle, text, [start=1]) returns 1-based position of needle, #VALUE! if not found
+func find(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	needle := asString(args[0])
+	start := optionalInt("find", args, 2, 1)
+	if start < 1 {
+		return errValue
+	}
+	return mapText(args[1], func(s string) CalculatedValue {
+		runes := []rune(s)
+		if start-1 > len(runes) {
+			return errValue
+		}
+		idx := strings.Index(string(runes[start-1:]), needle)
+		if idx < 0 {
+			return errValue
+		}
+		return intValue(start + utf8.RuneCountInString(string(runes[start-1:])[:idx]))
+	})
+}
+
+// replace(text, start, count, newText) replaces count characters starting at start
+func replace(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	start, n := asInt("replace", args[1]), asInt("replace", args[2])
+	newText := asString(args[3])
+	if start < 1 || n < 0 {
+		return errValue
+	}
+	return mapText(args[0], func(s string) CalculatedValue {
+		runeCount := utf8.RuneCountInString(s)
+		return stringValue(substr(s, 0, start-1) + newText + substr(s, start-1+n, runeCount))
+	})
+}
+
+// substitute(text, old, new, [instance]) replaces all occurrences of old, or only the given one
+func substitute(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	oldText, newText := asString(args[1]), asString(args[2])
+	instance := optionalInt("substitute", args, 3, 0)
+	if len(args) > 3 && instance < 1 {
+		return errValue
+	}
+	return mapText(args[0], func(s string) CalculatedValue {
+		if oldText == "" {
+			return stringValue(s)
+		}
+		if instance == 0 {
+			return stringValue(strings.ReplaceAll(s, oldText, newText))
+		}
+		offset := 0
+		for i := 1; ; i++ {
+			idx := strings.Index(s[offset:], oldText)
+			if idx < 0 {
+				return stringValue(s)
+			}
+			if i == instance {
+				pos := offset + idx
+				return stringValue(s[:pos] + newText + s[pos+len(oldText):])
+			}
+			offset += idx + len(oldText)
+		}
+	})
+}
+
+// repeat(text, count)
+func repeat(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	n := asInt("repeat", args[1])
+	if n < 0 {
+		return errValue
+	}
+	return mapText(args[0], func(s string) CalculatedValue {
+		return stringValue(strings.Repeat(s, n))
+	})
+}
+
+// join(separator, values...) joins all values, multi values are flattened
+func join(call *Call, args []CalculatedValue) CalculatedValue {
+	values := flattenValues(args[1:])
+	if e, ok := firstError(values); ok {
+		return e
+	}
+	sep := asString(args[0])
+	var buff bytes.Buffer
+	for i, v := range values {
+		if i != 0 {
+			buff.WriteString(sep)
+		}
+		buff.WriteString(asString(v))
+	}
+	return stringValue(buff.String())
+}
+
+func startsWith(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	prefix := asString(args[1])
+	return mapText(args[0], func(s string) CalculatedValue {
+		return boolValue(strings.HasPrefix(s, prefix))
+	})
+}
+
+func endsWith(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	suffix := asString(args[1])
+	return mapText(args[0], func(s string) CalculatedValue {
+		return boolValue(strings.HasSuffix(s, suffix))
+	})
+}
+
+func contains(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	needle := asString(args[1])
+	return mapText(args[0], func(s string) CalculatedValue {
+		return boolValue(strings.Contains(s, needle))
+	})
+}
+
+// pad(text, width, [fill=" "]) pads text on the left up to width characters,
+// negative width pads on the right; text longer than width is left as is
+func pad(call *Call, args []CalculatedValue) CalculatedValue {
+	if e, ok := firstError(args); ok {
+		return e
+	}
+	width := asInt("pad", args[1])
+	fill := " "
+	if len(args) > 2 {
+		fill = asString(args[2])
+	}
+	if utf8.RuneCountInString(fill) != 1 {
+		return errValue
+	}
+	padLeft := width >= 0
+	if !padLeft {
+		width = -width
+	}
+	return mapText(args[0], func(s string) CalculatedValue {
+		missing := width - utf8.RuneCountInString(s)
+		if missing <= 0 {
+			return stringValue(s)
+		}
+		padding := strings.Repeat(fill, missing)
+		if padLeft {
+			return stringValue(padding + s)
+		}
+		return stringValue(s + padding)
+	})
+}
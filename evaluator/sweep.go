@@ -0,0 +1,95 @@
+package evaluator
+
+import (
+	"fmt"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// ScenarioResult holds values of the outputs of a sweep for one scenario
+type ScenarioResult struct {
+	Scenario m.Scenario
+	Values   []CalculatedValue
+}
+
+// value of an expression evaluated like a formula below the sheet: labels are the ones defined last
+// and relative references like R[-1]C or @fee[@-1] reach the last row
+func calcOutput(es *evalState, output m.Expr) CalculatedValue {
+	rowIdx := len(es.csvCells)
+	if rowIdx == 0 {
+		return errRef
+	}
+	// a blank row for the output, taken away afterwards; sliced to capacity so the sheet's cells
+	// aren't written to
+	csvCells, evalCells, labelsOnRow := es.csvCells, es.evalCells, es.labelsOnRow
+	es.csvCells = append(csvCells[:rowIdx:rowIdx], []m.Cell{m.StringCell{}})
+	es.evalCells = append(evalCells[:rowIdx:rowIdx], make([]evalCell, 1))
+	es.labelsOnRow = append(labelsOnRow[:rowIdx:rowIdx], labelsOnRow[rowIdx-1])
+	defer func() {
+		es.csvCells, es.evalCells, es.labelsOnRow = csvCells, evalCells, labelsOnRow
+	}()
+	missingLabel := false
+	m.Walk(output, func(expr m.Expr) bool {
+		if label, ok := referencedLabel(expr); ok {
+			if _, found := es.labelsOnRow[rowIdx][label]; !found {
+				missingLabel = true
+			}
+		}
+		return true
+	})
+	if missingLabel {
+		return errRef
+	}
+	return calcExpr(es, &output, rowIdx, 0)
+}
+
+// CheckOutputs reports problems of sweep outputs of the workbook's first sheet, like Check does for
+// formulas of the sheet
+func CheckOutputs(wb m.Workbook, outputs []m.Expr, options ...Option) []string {
+	es := prepareWorkbook(wb, options)[0]
+	var labels labelMap
+	if len(es.labelsOnRow) > 0 {
+		labels = es.labelsOnRow[len(es.labelsOnRow)-1]
+	}
+	problems := make([]string, 0)
+	for _, output := range outputs {
+		for _, problem := range checkFormula(es.functions, output, nil, labels) {
+			problems = append(problems, fmt.Sprintf("%v: %s", output, problem))
+		}
+		for _, problem := range append(checkSheetRefs(es, output), checkParamRefs(es, output)...) {
+			problems = append(problems, fmt.Sprintf("%v: %s", output, problem))
+		}
+	}
+	return problems
+}
+
+// evaluates outputs once per scenario, with the scenario's parameters added to options; only cells
+// the outputs depend on are calculated
+func sweep(prepare func(options []Option) *evalState, scenarios []m.Scenario, outputs []m.Expr, options []Option) []ScenarioResult {
+	res := make([]ScenarioResult, len(scenarios))
+	for i, scenario := range scenarios {
+		es := prepare(append(append([]Option{}, options...), WithParams(scenario.Params)))
+		values := make([]CalculatedValue, len(outputs))
+		for j, output := range outputs {
+			values[j] = calcOutput(es, output)
+		}
+		res[i] = ScenarioResult{Scenario: scenario, Values: values}
+	}
+	return res
+}
+
+// Sweep evaluates the sheet once per scenario and collects values of the outputs, expressions like
+// E4 or @adjusted_cost<1> evaluated as if written below the sheet
+func Sweep(cells CSVCells, scenarios []m.Scenario, outputs []m.Expr, options ...Option) []ScenarioResult {
+	return sweep(func(options []Option) *evalState {
+		es := prepareState(cells, options)
+		return &es
+	}, scenarios, outputs, options)
+}
+
+// SweepWorkbook is Sweep for a workbook, outputs are evaluated in its first sheet
+func SweepWorkbook(wb m.Workbook, scenarios []m.Scenario, outputs []m.Expr, options ...Option) []ScenarioResult {
+	return sweep(func(options []Option) *evalState {
+		return prepareWorkbook(wb, options)[0]
+	}, scenarios, outputs, options)
+}
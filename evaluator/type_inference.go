@@ -0,0 +1,257 @@
+package evaluator
+
+import (
+	"fmt"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// SheetTypes holds static types of all cells and type conflicts found in formulas
+type SheetTypes struct {
+	Types       [][]ValueType
+	Diagnostics []Diagnostic
+}
+
+const (
+	notInferred = iota
+	inferring
+	inferred
+)
+
+type typeInferrer struct {
+	es          *evalState
+	types       [][]ValueType
+	progress    [][]int
+	diagnostics []Diagnostic
+}
+
+// InferTypes assigns a static type to each cell without evaluating the sheet, using function
+// signatures and types of referenced cells. Cells in reference cycles get UnknownType.
+func InferTypes(cells CSVCells, options ...Option) SheetTypes {
+	es := prepareState(cells, options)
+	ti := typeInferrer{
+		es:       &es,
+		types:    make([][]ValueType, len(es.csvCells)),
+		progress: make([][]int, len(es.csvCells)),
+	}
+	for rowIdx, row := range es.csvCells {
+		ti.types[rowIdx] = make([]ValueType, len(row))
+		ti.progress[rowIdx] = make([]int, len(row))
+	}
+	for rowIdx, row := range es.csvCells {
+		for colIdx := range row {
+			ti.cellType(rowIdx, colIdx)
+		}
+	}
+	return SheetTypes{
+		Types:       ti.types,
+		Diagnostics: ti.diagnostics,
+	}
+}
+
+func (ti *typeInferrer) report(rowIdx, colIdx int, format string, args ...any) {
+	ti.diagnostics = append(ti.diagnostics, Diagnostic{
+		Row:     rowIdx,
+		Col:     colIdx,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (ti *typeInferrer) cellType(rowIdx, colIdx int) ValueType {
+	if rowIdx < 0 || rowIdx >= len(ti.types) || colIdx < 0 || colIdx >= len(ti.types[rowIdx]) {
+		return UnknownType
+	}
+	switch ti.progress[rowIdx][colIdx] {
+	case inferred:
+		return ti.types[rowIdx][colIdx]
+	case inferring:
+		// reference cycle
+		return UnknownType
+	}
+	ti.progress[rowIdx][colIdx] = inferring
+	var t ValueType
+	switch v := ti.es.csvCells[rowIdx][colIdx].(type) {
+	case m.IntCell:
+		t = IntType
+	case m.FloatCell:
+		t = FloatType
+	case m.StringCell, m.LabelCell, m.FunctionDefCell:
+		t = StringType
+	case m.FormulaCell:
+		t = ti.exprType(v.Formula, rowIdx, colIdx)
+	}
+	ti.types[rowIdx][colIdx] = t
+	ti.progress[rowIdx][colIdx] = inferred
+	return t
+}
+
+func (ti *typeInferrer) exprType(expr m.Expr, rowIdx, colIdx int) ValueType {
+	switch v := expr.(type) {
+	case m.IntLit:
+		return IntType
+	case m.FloatLit:
+		return FloatType
+	case m.StringLit:
+		return StringType
+	case m.InfixOp:
+		return ti.infixOpType(v, rowIdx, colIdx)
+	case m.Negation:
+		t := ti.exprType(v.Expr, rowIdx, colIdx)
+		if t == StringType || t == BoolType || t == LambdaType {
+			ti.report(rowIdx, colIdx, "type conflict: -%v in %v", t, v)
+			return ErrorType
+		}
+		return t
+	case m.FunCall:
+		return ti.funCallType(v, rowIdx, colIdx)
+	case m.CellRef:
+		return ti.cellType(v.Row-1, colNameToIdx(v.Col))
+	case m.R1C1Ref:
+		return ti.cellType(v.Target(rowIdx, colIdx))
+	case m.ParamRef:
+		return valueType(calcParamRef(ti.es, v))
+	case m.CopyAbove:
+		// the formula above moved here
+		if formula, ok := copiedFormula(ti.es.csvCells, rowIdx, colIdx); ok {
+			return ti.exprType(formula, rowIdx, colIdx)
+		}
+		return ti.cellType(rowIdx-1, colIdx)
+	case m.CopyColumnAbove:
+		return ti.cellType(rowIdx-1, colNameToIdx(v.Col))
+	case m.CopyLastInColumn:
+		targetColIdx := colNameToIdx(v.Col)
+		for targetRowIdx := rowIdx - 1; targetRowIdx >= 0; targetRowIdx-- {
+			if len(ti.es.csvCells[targetRowIdx]) > targetColIdx {
+				return ti.cellType(targetRowIdx, targetColIdx)
+			}
+		}
+		return UnknownType
+	case m.LabelRelativeRowRef:
+		labelAnchor, found := ti.es.labelsOnRow[rowIdx][v.Label]
+		if !found {
+			return UnknownType
+		}
+		targetRowIdx := labelAnchor.rowIdx + v.RelativeRow
+		if v.FromCurrentRow {
+			targetRowIdx = rowIdx + v.RelativeRow
+		}
+		if !isInSheet(ti.es, targetRowIdx, labelAnchor.colIdx) {
+			return ErrorType
+		}
+		return ti.cellType(targetRowIdx, labelAnchor.colIdx)
+	case m.CellRange, m.R1C1Range, m.LabelBlock, m.SpillRef:
+		return MultiType
+	case m.LabelColumnRef:
+		if v.Whole || v.Slice {
+			return MultiType
+		}
+		labelAnchor, found := ti.es.labelsOnRow[rowIdx][v.Label]
+		if !found {
+			return UnknownType
+		}
+		idx, ok := arrayIndex(v.From, labelBlockLastRow(ti.es, labelAnchor)-labelAnchor.rowIdx)
+		if !ok {
+			return ErrorType
+		}
+		return ti.cellType(labelAnchor.rowIdx+1+idx, labelAnchor.colIdx)
+	case m.ArrayLit:
+		for _, row := range v.Rows {
+			for _, elem := range row {
+				ti.exprType(elem, rowIdx, colIdx)
+			}
+		}
+		return MultiType
+	case m.Let:
+		for _, value := range v.Values {
+			ti.exprType(value, rowIdx, colIdx)
+		}
+		return ti.exprType(v.Body, rowIdx, colIdx)
+	case m.Lambda:
+		// checks the body, its parameters are of unknown type
+		ti.exprType(v.Body, rowIdx, colIdx)
+		return LambdaType
+	default:
+		return UnknownType
+	}
+}
+
+// formula that ^^ copies to the given cell, with references shifted; not ok when the cell above
+// has no formula
+func copiedFormula(cells CSVCells, rowIdx, colIdx int) (m.Expr, bool) {
+	for offset := 1; rowIdx-offset >= 0 && colIdx < len(cells[rowIdx-offset]); offset++ {
+		formulaCell, ok := cells[rowIdx-offset][colIdx].(m.FormulaCell)
+		if !ok {
+			return nil, false
+		}
+		if _, isCopy := formulaCell.Formula.(m.CopyAbove); !isCopy {
+			return m.ShiftRows(formulaCell.Formula, offset), true
+		}
+	}
+	return nil, false
+}
+
+func isNumericType(t ValueType) bool {
+	return t == IntType || t == FloatType
+}
+
+// mirrors infix operation evaluation rules
+func (ti *typeInferrer) infixOpType(v m.InfixOp, rowIdx, colIdx int) ValueType {
+	lhs, rhs := ti.exprType(v.Lhs, rowIdx, colIdx), ti.exprType(v.Rhs, rowIdx, colIdx)
+	switch {
+	case lhs == ErrorType || rhs == ErrorType:
+		return ErrorType
+	case lhs == MultiType || rhs == MultiType:
+		// element-wise
+		return MultiType
+	case lhs == IntType && rhs == IntType:
+		return IntType
+	case isNumericType(lhs) && isNumericType(rhs):
+		return FloatType
+	case v.Op == m.ADD && lhs == StringType && (isNumericType(rhs) || rhs == StringType):
+		return StringType
+	case lhs == UnknownType || rhs == UnknownType:
+		return UnknownType
+	}
+	ti.report(rowIdx, colIdx, "type conflict: %v %v %v in %v", lhs, v.Op, rhs, v)
+	return ErrorType
+}
+
+func (ti *typeInferrer) funCallType(fc m.FunCall, rowIdx, colIdx int) ValueType {
+	argTypes := make([]ValueType, len(fc.Params))
+	for i, param := range fc.Params {
+		argTypes[i] = ti.exprType(param, rowIdx, colIdx)
+	}
+	spec, ok := ti.es.functions.Lookup(fc.Name)
+	if !ok {
+		return UnknownType
+	}
+	if !spec.Lazy && !hasSpreadParam(fc) {
+		for i, param := range fc.Params {
+			if _, isLiteral := literalValue(param); isLiteral {
+				// reported by Check
+				continue
+			}
+			if i == 0 && spec.ElementWise && argTypes[i] == MultiType {
+				// elements are checked when called
+				continue
+			}
+			if t := spec.ParamType(i); !t.acceptsType(argTypes[i]) {
+				ti.report(rowIdx, colIdx, "type conflict: function %s() argument %d must be of %v type, got %v from %v",
+					fc.Name, i+1, t, argTypes[i], param)
+			}
+		}
+	}
+	if spec.ElementWise && len(argTypes) > 0 && argTypes[0] == MultiType {
+		return MultiType
+	}
+	return spec.Returns
+}
+
+func hasSpreadParam(fc m.FunCall) bool {
+	for _, param := range fc.Params {
+		if isSpreadCall(param) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package evaluator
+
+// ValueType is a static type of a cell or expression
+type ValueType int
+
+const (
+	UnknownType ValueType = iota
+	IntType
+	FloatType
+	StringType
+	BoolType
+	MultiType
+	ErrorType
+	LambdaType
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case IntType:
+		return "int"
+	case FloatType:
+		return "float"
+	case StringType:
+		return "string"
+	case BoolType:
+		return "bool"
+	case MultiType:
+		return "multi"
+	case ErrorType:
+		return "error"
+	case LambdaType:
+		return "lambda"
+	default:
+		return "unknown"
+	}
+}
+
+// type of a calculated value
+func valueType(v CalculatedValue) ValueType {
+	switch v.(type) {
+	case intValue:
+		return IntType
+	case floatValue:
+		return FloatType
+	case stringValue:
+		return StringType
+	case boolValue:
+		return BoolType
+	case multiValue, spreadValue:
+		return MultiType
+	case errorValue:
+		return ErrorType
+	case lambdaValue:
+		return LambdaType
+	default:
+		return UnknownType
+	}
+}
+
+// ValueTypeOf returns the type of a calculated value
+func ValueTypeOf(v CalculatedValue) ValueType {
+	return valueType(v)
+}
+
+// whether a parameter of type t can ever accept a value of static type vt
+func (t ParamType) acceptsType(vt ValueType) bool {
+	if vt == UnknownType || vt == ErrorType {
+		return true
+	}
+	switch t {
+	case NumberParam, IntParam:
+		// strings can be numeric
+		return vt == IntType || vt == FloatType || vt == StringType
+	case StringParam:
+		return vt == StringType
+	case BoolParam:
+		return vt != MultiType
+	case MultiParam:
+		return vt == MultiType
+	case LambdaParam:
+		return vt == LambdaType
+	default:
+		return true
+	}
+}
@@ -0,0 +1,61 @@
+package evaluator
+
+// Constructors and accessors of calculated values, for custom functions
+
+func NewInt(v int) CalculatedValue {
+	return intValue(v)
+}
+
+func NewFloat(v float64) CalculatedValue {
+	return floatValue(v)
+}
+
+func NewString(v string) CalculatedValue {
+	return stringValue(v)
+}
+
+func NewBool(v bool) CalculatedValue {
+	return boolValue(v)
+}
+
+func NewMulti(values ...CalculatedValue) CalculatedValue {
+	return multiValue(values)
+}
+
+// NewError creates an error value, code should look like other spreadsheet errors, e.g. #VALUE!
+func NewError(code string) CalculatedValue {
+	return errorValue(code)
+}
+
+// ToNumber converts int, float or numeric string value to a number
+func ToNumber(v CalculatedValue) (float64, bool) {
+	return asNumber(v)
+}
+
+// ToInt returns value of an int value
+func ToInt(v CalculatedValue) (int, bool) {
+	i, ok := v.(intValue)
+	return int(i), ok
+}
+
+// ToBool returns value of a bool value
+func ToBool(v CalculatedValue) (bool, bool) {
+	b, ok := v.(boolValue)
+	return bool(b), ok
+}
+
+// ToMulti returns elements of a multi value
+func ToMulti(v CalculatedValue) ([]CalculatedValue, bool) {
+	mv, ok := v.(multiValue)
+	return mv, ok
+}
+
+func IsString(v CalculatedValue) bool {
+	_, ok := v.(stringValue)
+	return ok
+}
+
+func IsError(v CalculatedValue) bool {
+	_, ok := v.(errorValue)
+	return ok
+}
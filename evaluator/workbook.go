@@ -0,0 +1,124 @@
+package evaluator
+
+import (
+	"fmt"
+
+	m "pasza.org/sr-challenge/model"
+)
+
+// sheets of a workbook by name, formulas of each sheet can reach the others
+type workbookState map[string]*evalState
+
+// SheetValues are calculated values of a workbook sheet
+type SheetValues struct {
+	Name   string
+	Values [][]CalculatedValue
+}
+
+// states of all sheets of the workbook, in order
+func prepareWorkbook(wb m.Workbook, options []Option) []*evalState {
+	states := make([]*evalState, len(wb.Sheets))
+	sheets := make(workbookState, len(wb.Sheets))
+	for i, sheet := range wb.Sheets {
+		es := prepareState(sheet.Cells, options)
+		es.workbook = sheets
+		if i > 0 {
+			// a probe for spilled arrays may reach cells of other sheets
+			es.stack = states[0].stack
+		}
+		states[i] = &es
+		sheets[sheet.Name] = &es
+	}
+	return states
+}
+
+// EvaluateWorkbook evaluates all sheets of the workbook together, a reference cycle spanning
+// sheets evaluates to #CYCLE! like one within a sheet
+func EvaluateWorkbook(wb m.Workbook, options ...Option) []SheetValues {
+	states := prepareWorkbook(wb, options)
+	res := make([]SheetValues, len(states))
+	for i, es := range states {
+		calculateAll(es, es.csvCells)
+		res[i].Name = wb.Sheets[i].Name
+	}
+	for i, es := range states {
+		res[i].Values = sheetValues(es)
+	}
+	return res
+}
+
+// row a reference to the sheet is evaluated at: cell references stay relative to the formula cell,
+// labels are the ones visible at the end of the sheet, so the last definition of a label wins
+func sheetRefRow(target *evalState, ref m.Expr, rowIdx int) (int, bool) {
+	label, isLabel := referencedLabel(ref)
+	if !isLabel {
+		return rowIdx, true
+	}
+	lastRowIdx := len(target.labelsOnRow) - 1
+	if lastRowIdx < 0 {
+		return 0, false
+	}
+	_, found := target.labelsOnRow[lastRowIdx][label]
+	return lastRowIdx, found
+}
+
+// e.g. Prices!A1 or Prices!@eth<1>, #REF! for unknown sheets and labels
+func calcSheetRef(es *evalState, v m.SheetRef, rowIdx, colIdx int) CalculatedValue {
+	target, found := es.workbook[v.Sheet]
+	if !found {
+		return errRef
+	}
+	refRowIdx, found := sheetRefRow(target, v.Ref, rowIdx)
+	if !found {
+		return errRef
+	}
+	return calcExpr(target, &v.Ref, refRowIdx, colIdx)
+}
+
+// reports references to sheets or labels missing from the workbook
+func checkSheetRefs(es *evalState, formula m.Expr) []string {
+	problems := make([]string, 0)
+	m.Walk(formula, func(expr m.Expr) bool {
+		v, ok := expr.(m.SheetRef)
+		if !ok {
+			return true
+		}
+		target, found := es.workbook[v.Sheet]
+		if !found {
+			problems = append(problems, fmt.Sprintf("unknown sheet %s", v.Sheet))
+		} else if _, found := sheetRefRow(target, v.Ref, 0); !found {
+			label, _ := referencedLabel(v.Ref)
+			problems = append(problems, fmt.Sprintf("unknown label %s!@%s", v.Sheet, label))
+		}
+		return false
+	})
+	return problems
+}
+
+// CheckWorkbook runs Check for all sheets of the workbook, references to other sheets are checked too
+func CheckWorkbook(wb m.Workbook, options ...Option) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0)
+	for i, es := range prepareWorkbook(wb, options) {
+		for _, d := range checkSheet(es) {
+			d.Sheet = wb.Sheets[i].Name
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	return diagnostics
+}
+
+// ExplainWorkbook runs Explain for all sheets of the workbook, evaluated together
+func ExplainWorkbook(wb m.Workbook, options ...Option) []Explanation {
+	states := prepareWorkbook(wb, options)
+	for _, es := range states {
+		calculateAll(es, es.csvCells)
+	}
+	res := make([]Explanation, 0)
+	for i, es := range states {
+		for _, e := range explanations(es) {
+			e.Sheet = wb.Sheets[i].Name
+			res = append(res, e)
+		}
+	}
+	return res
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"pasza.org/sr-challenge/evaluator"
+	"pasza.org/sr-challenge/model"
+	"pasza.org/sr-challenge/parser"
+)
+
+type goalSeekOptions struct {
+	goal         evaluator.GoalSeek
+	valueSet     bool
+	params       map[string]model.Cell
+	seed         int64
+	seedSet      bool
+	includePaths []string
+}
+
+func parseExprFlag(dst *model.Expr) func(string) error {
+	return func(s string) error {
+		expr, err := parser.ParseExpr(s)
+		*dst = expr
+		return err
+	}
+}
+
+func parseFloatFlag(dst *float64) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		*dst = v
+		return err
+	}
+}
+
+func parseGoalSeekOptions(args []string) (opts goalSeekOptions, flags *flag.FlagSet) {
+	opts.params = make(map[string]model.Cell)
+	flags = flag.NewFlagSet("goalseek", flag.ExitOnError)
+	flags.Func("input", "number cell to change, like A6 or @fee<1>", parseExprFlag(&opts.goal.Input))
+	flags.Func("target", "cell or formula to reach the value, like E4 or @adjusted_cost<1>", parseExprFlag(&opts.goal.Target))
+	flags.Func("value", "value for the target to reach, true or false for the input where it flips", func(s string) error {
+		opts.valueSet = true
+		switch s {
+		case "true":
+			opts.goal.Value = 1
+			return nil
+		case "false":
+			opts.goal.Value = 0
+			return nil
+		}
+		return parseFloatFlag(&opts.goal.Value)(s)
+	})
+	flags.Func("low", "lowest input value to try, with -high", parseFloatFlag(&opts.goal.Low))
+	flags.Func("high", "highest input value to try, with -low", parseFloatFlag(&opts.goal.High))
+	flags.Float64Var(&opts.goal.Tolerance, "tolerance", 1e-9, "how close the target has to get, or the input to the flip of true/false targets")
+	flags.IntVar(&opts.goal.MaxIterations, "iterations", 100, "most evaluations of the sheet")
+	flags.Func("D", "parameter name=value for formulas, referenced as $name (repeatable)", func(s string) error {
+		name, value, err := parser.ParseParam(s)
+		if err != nil {
+			return err
+		}
+		opts.params[name] = value
+		return nil
+	})
+	flags.Func("seed", "seed for rand() and randbetween(), the same for every evaluation", func(s string) error {
+		_, err := fmt.Sscan(s, &opts.seed)
+		opts.seedSet = err == nil
+		return err
+	})
+	flags.Func("I", "directory to look for included files in (repeatable)", func(s string) error {
+		opts.includePaths = append(opts.includePaths, s)
+		return nil
+	})
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s goalseek -input <cell> -target <formula> -value <value> [options] <input_file> [output_file]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	return
+}
+
+// looks for the input value making the target reach the value and writes it with the target's value
+func runGoalSeek(args []string) {
+	opts, flags := parseGoalSeekOptions(args)
+	inputPath, outputPath := validateCommandLine(flags.Args(), flags.Usage)
+	if opts.goal.Input == nil || opts.goal.Target == nil || !opts.valueSet {
+		log.Fatal("-input, -target and -value are required\n")
+		os.Exit(1)
+	}
+	wb, err := parser.LoadWorkbook(opts.includePaths, inputPath)
+	if err != nil {
+		log.Fatalf("failed to load: %v\n", err)
+		os.Exit(1)
+	}
+	evalOptions := []evaluator.Option{evaluator.WithParams(opts.params)}
+	if opts.seedSet {
+		evalOptions = append(evalOptions, evaluator.WithSeed(opts.seed))
+	}
+
+	errorCount := 0
+	for _, d := range evaluator.CheckWorkbook(wb, evalOptions...) {
+		log.Println(d)
+		if !d.Warning {
+			errorCount++
+		}
+	}
+	for _, problem := range evaluator.CheckOutputs(wb, []model.Expr{opts.goal.Target}, evalOptions...) {
+		log.Println(problem)
+		errorCount++
+	}
+	if errorCount > 0 {
+		log.Fatalf("%d problem(s) found in formulas\n", errorCount)
+		os.Exit(1)
+	}
+
+	res, err := evaluator.SeekWorkbook(wb, opts.goal, evalOptions...)
+	if err != nil {
+		log.Fatal(err)
+		os.Exit(1)
+	}
+	writeOutput(outputPath, [][]text{
+		{"!input", "!value", "!target", "!result", "!evaluations"},
+		{
+			text(fmt.Sprintf("%v", opts.goal.Input)),
+			text(strconv.FormatFloat(res.Input, 'f', -1, 64)),
+			text(fmt.Sprintf("%v", opts.goal.Target)),
+			text(res.Target.String()),
+			text(strconv.Itoa(res.Evaluations)),
+		},
+	})
+}
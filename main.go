@@ -2,11 +2,15 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 
 	"pasza.org/sr-challenge/evaluator"
+	"pasza.org/sr-challenge/model"
 	"pasza.org/sr-challenge/parser"
 )
 
@@ -15,22 +19,101 @@ func fileExists(path string) bool {
 	return err == nil
 }
 
-func validateCommandLine() (inputPath, outputPath string) {
-	argv := os.Args
+type options struct {
+	seed      int64
+	seedSet   bool
+	showTypes bool
+	explain   bool
+	// print formulas in this notation instead of evaluating
+	formulas    model.Notation
+	formulasSet bool
+	// other files with sheets of the workbook
+	sheetPaths []string
+	// directories to look for files of !include rows in
+	includePaths []string
+	params       map[string]model.Cell
+	json         bool
+}
+
+func parseOptions() (opts options) {
+	opts.params = make(map[string]model.Cell)
+	flag.Func("seed", "seed for rand() and randbetween(), for reproducible output", func(s string) error {
+		seed, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		opts.seed, opts.seedSet = seed, true
+		return nil
+	})
+	flag.BoolVar(&opts.showTypes, "types", false, "print inferred cell types instead of evaluating")
+	flag.BoolVar(&opts.explain, "explain", false, "print formulas evaluated for each cell with their values")
+	flag.Func("formulas", "print the sheet with formulas in a1 or r1c1 notation instead of evaluating", func(s string) error {
+		switch s {
+		case "a1":
+			opts.formulas = model.A1Notation
+		case "r1c1":
+			opts.formulas = model.R1C1Notation
+		default:
+			return fmt.Errorf("unknown notation %q, use a1 or r1c1", s)
+		}
+		opts.formulasSet = true
+		return nil
+	})
+	flag.Func("format", "output format of evaluated sheets, text or json", func(s string) error {
+		switch s {
+		case "text":
+			opts.json = false
+		case "json":
+			opts.json = true
+		default:
+			return fmt.Errorf("unknown format %q, use text or json", s)
+		}
+		return nil
+	})
+	flag.Func("sheet", "add sheets of another file to the workbook, named after the file (repeatable)", func(s string) error {
+		if !fileExists(s) {
+			return fmt.Errorf("sheet file %s does not exist", s)
+		}
+		opts.sheetPaths = append(opts.sheetPaths, s)
+		return nil
+	})
+	flag.Func("I", "directory to look for included files in, after the including file's one (repeatable)", func(s string) error {
+		opts.includePaths = append(opts.includePaths, s)
+		return nil
+	})
+	flag.Func("D", "parameter name=value for formulas, referenced as $name (repeatable)", func(s string) error {
+		name, value, err := parser.ParseParam(s)
+		if err != nil {
+			return err
+		}
+		opts.params[name] = value
+		return nil
+	})
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <input_file> [output_file]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s sweep [sweep options] <input_file> [output_file]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s goalseek [goal seek options] <input_file> [output_file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	return
+}
+
+func validateCommandLine(argv []string, usage func()) (inputPath, outputPath string) {
 	argc := len(argv)
 
 	switch argc {
+	case 1:
+		inputPath = argv[0]
 	case 2:
-		inputPath = argv[1]
-	case 3:
-		inputPath = argv[1]
-		outputPath = argv[2]
+		inputPath = argv[0]
+		outputPath = argv[1]
 		if fileExists(outputPath) {
 			log.Fatalf("Output file already exists")
 			os.Exit(1)
 		}
 	default:
-		fmt.Printf("Usage: %s <input_file> [output_file]\n", argv[0])
+		usage()
 		os.Exit(1)
 	}
 	if !fileExists(inputPath) {
@@ -40,8 +123,15 @@ func validateCommandLine() (inputPath, outputPath string) {
 	return
 }
 
+// cell text written as is
+type text string
+
+func (t text) String() string {
+	return string(t)
+}
+
 // Writes output to file or to stdout
-func writeOutput(outputPath string, result [][]evaluator.CalculatedValue) {
+func writeOutput[T fmt.Stringer](outputPath string, result [][]T) {
 	var f *os.File
 	var err error
 	if outputPath != "" {
@@ -72,25 +162,154 @@ func writeOutput(outputPath string, result [][]evaluator.CalculatedValue) {
 	defer writer.Flush()
 }
 
+func asText(rows [][]string) [][]text {
+	lines := make([][]text, len(rows))
+	for i, row := range rows {
+		lines[i] = make([]text, len(row))
+		for j, cell := range row {
+			lines[i][j] = text(cell)
+		}
+	}
+	return lines
+}
+
+// Writes the value as indented JSON to file or to stdout
+func writeJSON(outputPath string, v any) {
+	f := os.Stdout
+	if outputPath != "" {
+		var err error
+		f, err = os.OpenFile(outputPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open output file")
+			os.Exit(1)
+		}
+		defer f.Close()
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Fatalf("Failed to write JSON: %v", err)
+		os.Exit(1)
+	}
+}
+
+// indexes of sheets written out: sheets of the input and -sheet files, not included ones
+func printedSheets(wb model.Workbook) []int {
+	printed := make([]int, 0, len(wb.Sheets))
+	for i, sheet := range wb.Sheets {
+		if !sheet.Included {
+			printed = append(printed, i)
+		}
+	}
+	return printed
+}
+
+// writes a !sheet row before each written sheet of a workbook, a single one is written as is
+func writeSheetHeader(outputPath string, wb model.Workbook, name string) {
+	if len(printedSheets(wb)) > 1 {
+		writeOutput(outputPath, [][]text{{"!sheet", text(name)}})
+	}
+}
+
 func main() {
-	inputPath, outputPath := validateCommandLine()
-	input, err := os.ReadFile(inputPath)
+	if len(os.Args) > 1 && os.Args[1] == "sweep" {
+		runSweep(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "goalseek" {
+		runGoalSeek(os.Args[2:])
+		return
+	}
+	opts := parseOptions()
+	inputPath, outputPath := validateCommandLine(flag.Args(), flag.Usage)
+	wb, err := parser.LoadWorkbook(opts.includePaths, append([]string{inputPath}, opts.sheetPaths...)...)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("failed to load: %v\n", err)
 		os.Exit(1)
 	}
-	csv, ok, err := parser.ParseCSV(string(input))
-	if err != nil {
-		log.Fatalf("failed to parse: %v\n", err)
+	workbook := len(wb.Sheets) > 1
+	if opts.json && (opts.formulasSet || opts.showTypes || opts.explain) {
+		log.Fatal("-format json is only for evaluated sheets, not with -formulas, -types or -explain\n")
 		os.Exit(1)
 	}
 
-	if !ok {
-		log.Fatal("expected CSV not matched\n")
+	evalOptions := []evaluator.Option{evaluator.WithParams(opts.params)}
+	if opts.seedSet {
+		evalOptions = append(evalOptions, evaluator.WithSeed(opts.seed))
+	}
+
+	// check formulas before running them
+	var diagnostics []evaluator.Diagnostic
+	if workbook {
+		diagnostics = evaluator.CheckWorkbook(wb, evalOptions...)
+	} else {
+		diagnostics = evaluator.Check(wb.Sheets[0].Cells, evalOptions...)
+	}
+	types := make([]evaluator.SheetTypes, len(wb.Sheets))
+	for i, sheet := range wb.Sheets {
+		types[i] = evaluator.InferTypes(sheet.Cells, evalOptions...)
+		for _, d := range types[i].Diagnostics {
+			if workbook {
+				d.Sheet = sheet.Name
+			}
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	errorCount := 0
+	for _, d := range diagnostics {
+		log.Println(d)
+		if !d.Warning {
+			errorCount++
+		}
+	}
+	if errorCount > 0 {
+		log.Fatalf("%d problem(s) found in formulas\n", errorCount)
 		os.Exit(1)
 	}
+	if opts.formulasSet {
+		for _, i := range printedSheets(wb) {
+			writeSheetHeader(outputPath, wb, wb.Sheets[i].Name)
+			writeOutput(outputPath, asText(model.FormatSheet(wb.Sheets[i].Cells, opts.formulas)))
+		}
+		return
+	}
+	if opts.showTypes {
+		for _, i := range printedSheets(wb) {
+			writeSheetHeader(outputPath, wb, wb.Sheets[i].Name)
+			writeOutput(outputPath, types[i].Types)
+		}
+		return
+	}
 	// evaluate
-	result := evaluator.Evaluate(csv)
+	if opts.explain {
+		var explanations []evaluator.Explanation
+		if workbook {
+			explanations = evaluator.ExplainWorkbook(wb, evalOptions...)
+		} else {
+			explanations = evaluator.Explain(wb.Sheets[0].Cells, evalOptions...)
+		}
+		lines := make([][]evaluator.Explanation, len(explanations))
+		for i, e := range explanations {
+			lines[i] = []evaluator.Explanation{e}
+		}
+		writeOutput(outputPath, lines)
+		return
+	}
 	// format output
-	writeOutput(outputPath, result)
+	if opts.json {
+		sheets := evaluator.EvaluateWorkbookJSON(wb, evalOptions...)
+		printed := make([]evaluator.JSONSheet, 0, len(sheets))
+		for _, i := range printedSheets(wb) {
+			printed = append(printed, sheets[i])
+		}
+		writeJSON(outputPath, struct {
+			Sheets []evaluator.JSONSheet `json:"sheets"`
+		}{printed})
+		return
+	}
+	sheets := evaluator.EvaluateWorkbook(wb, evalOptions...)
+	for _, i := range printedSheets(wb) {
+		writeSheetHeader(outputPath, wb, sheets[i].Name)
+		writeOutput(outputPath, sheets[i].Values)
+	}
 }
@@ -24,12 +24,20 @@ type FormulaCell struct {
 	Formula Expr
 }
 
+// function defined in a !def row, e.g. withFee(x) = x + x*@fee<1>
+type FunctionDefCell struct {
+	Name   string
+	Params []string
+	Body   Expr
+}
+
 type Formula interface {
 	isFormula()
 }
 
-func (StringCell) isCell()  {}
-func (IntCell) isCell()     {}
-func (FloatCell) isCell()   {}
-func (LabelCell) isCell()   {}
-func (FormulaCell) isCell() {}
+func (StringCell) isCell()      {}
+func (IntCell) isCell()         {}
+func (FloatCell) isCell()       {}
+func (LabelCell) isCell()       {}
+func (FormulaCell) isCell()     {}
+func (FunctionDefCell) isCell() {}
@@ -22,12 +22,18 @@ type IntLit int
 type FloatLit float64
 type StringLit string
 
+// e.g. A1, $ anchors the column and/or row so they are kept when the formula is copied: $A$1
 type CellRef struct {
-	Col string
-	Row int
+	Col         string
+	Row         int
+	ColAnchored bool
+	RowAnchored bool
 }
 
 type CopyAbove struct{}
+
+// =^^* copies the formula above like ^^, into its own cell and the column below it
+type FillDown struct{}
 type CopyLastInColumn struct {
 	Col string
 }
@@ -35,9 +41,87 @@ type CopyColumnAbove struct {
 	Col string
 }
 
+// a cell in the column of a label, RelativeRow rows below the label (@label<n>)
+// or below the current row (@label[@+n]); negative offsets go up
 type LabelRelativeRowRef struct {
-	Label       string
-	RelativeRow int
+	Label          string
+	RelativeRow    int
+	FromCurrentRow bool
+}
+
+// e.g. A1:C3
+type CellRange struct {
+	From CellRef
+	To   CellRef
+}
+
+// a cell in R1C1 notation: R3C4 is the cell D3, R[-1]C[2] and RC[-1] are relative
+// to the cell holding the formula; R and C without a number stand for its own row or column
+type R1C1Ref struct {
+	Row         int
+	Col         int
+	RowRelative bool
+	ColRelative bool
+}
+
+// e.g. R1C1:R[-1]C
+type R1C1Range struct {
+	From R1C1Ref
+	To   R1C1Ref
+}
+
+// a named parameter given for the evaluation, e.g. $fee
+type ParamRef struct {
+	Name string
+}
+
+// a reference to a cell, range or label of another sheet in the workbook, e.g. Prices!A1
+type SheetRef struct {
+	Sheet string
+	Ref   Expr
+}
+
+// the whole array spilled from a cell, e.g. A2#
+type SpillRef struct {
+	Anchor CellRef
+}
+
+// the table of values under a label, e.g. @token_prices
+type LabelBlock struct {
+	Label string
+}
+
+// values in the column under a label: all of them with @label[*], some with @label[2:5] or a single
+// one with @label[-1]; positions are 1-based, negative ones count from the end of the column
+type LabelColumnRef struct {
+	Label string
+	From  int
+	To    int  // same as From for a single value
+	Whole bool // [*]
+	Slice bool // [from:to]
+}
+
+// a name bound in the formula, e.g. parameter of a function defined in the sheet
+type LocalName struct {
+	Name string
+}
+
+// array literal, e.g. {1, 2, 3} or {1, 2; 3, 4} with rows separated by ;
+type ArrayLit struct {
+	Rows [][]Expr
+}
+
+// let(name1, value1, ..., body) binds names to values for the rest of the expression
+type Let struct {
+	Names  []string
+	Values []Expr
+	Body   Expr
+}
+
+// lambda(param1, ..., body) is a function value, e.g. for map()
+type Lambda struct {
+	Params []string
+	Body   Expr
 }
 
 type InfixOp struct {
@@ -46,6 +130,11 @@ type InfixOp struct {
 	Op  BinaryOperator
 }
 
+// -x of anything but a number literal, e.g. -A1 or -(1 + 2)
+type Negation struct {
+	Expr Expr
+}
+
 type NoResult struct{}
 
 // Make sure all the expression variants implement Expr
@@ -53,10 +142,24 @@ func (IntLit) isExpr()    {}
 func (FloatLit) isExpr()  {}
 func (StringLit) isExpr() {}
 func (InfixOp) isExpr()   {}
+func (Negation) isExpr()  {}
 func (FunCall) isExpr()   {}
 
 func (CellRef) isExpr()             {}
 func (CopyAbove) isExpr()           {}
+func (FillDown) isExpr()            {}
 func (CopyLastInColumn) isExpr()    {}
 func (LabelRelativeRowRef) isExpr() {}
 func (CopyColumnAbove) isExpr()     {}
+func (CellRange) isExpr()           {}
+func (SpillRef) isExpr()            {}
+func (R1C1Ref) isExpr()             {}
+func (R1C1Range) isExpr()           {}
+func (SheetRef) isExpr()            {}
+func (ParamRef) isExpr()            {}
+func (LabelBlock) isExpr()          {}
+func (LabelColumnRef) isExpr()      {}
+func (LocalName) isExpr()           {}
+func (Let) isExpr()                 {}
+func (Lambda) isExpr()              {}
+func (ArrayLit) isExpr()            {}
@@ -39,14 +39,74 @@ func (op InfixOp) String() string {
 	return fmt.Sprintf("%v %v %v", lhs, op.Op, rhs)
 }
 
+func (v Negation) String() string {
+	return "-" + formatInfixOperand(v.Expr)
+}
+
+func anchor(anchored bool) string {
+	if anchored {
+		return "$"
+	}
+	return ""
+}
+
 func (cellRef CellRef) String() string {
-	return fmt.Sprintf("%s%d", cellRef.Col, cellRef.Row)
+	return fmt.Sprintf("%s%s%s%d", anchor(cellRef.ColAnchored), cellRef.Col, anchor(cellRef.RowAnchored), cellRef.Row)
+}
+
+func (r CellRange) String() string {
+	return fmt.Sprintf("%v:%v", r.From, r.To)
+}
+
+func r1c1Part(n int, relative bool) string {
+	switch {
+	case !relative:
+		return strconv.Itoa(n)
+	case n == 0:
+		return ""
+	default:
+		return fmt.Sprintf("[%d]", n)
+	}
+}
+
+func (v R1C1Ref) String() string {
+	return fmt.Sprintf("R%sC%s", r1c1Part(v.Row, v.RowRelative), r1c1Part(v.Col, v.ColRelative))
+}
+
+func (r R1C1Range) String() string {
+	return fmt.Sprintf("%v:%v", r.From, r.To)
+}
+
+func (v ParamRef) String() string {
+	return "$" + v.Name
+}
+
+func (v SheetRef) String() string {
+	return fmt.Sprintf("%s!%v", v.Sheet, v.Ref)
+}
+
+func (v LabelRelativeRowRef) String() string {
+	if v.FromCurrentRow && v.RelativeRow == 0 {
+		return fmt.Sprintf("@%s[@]", v.Label)
+	}
+	if v.FromCurrentRow {
+		return fmt.Sprintf("@%s[@%+d]", v.Label, v.RelativeRow)
+	}
+	return fmt.Sprintf("@%s<%d>", v.Label, v.RelativeRow)
+}
+
+func (v LabelBlock) String() string {
+	return fmt.Sprintf("@%s", v.Label)
 }
 
 func (CopyAbove) String() string {
 	return "^^"
 }
 
+func (FillDown) String() string {
+	return "^^*"
+}
+
 func (v CopyLastInColumn) String() string {
 	return fmt.Sprintf("%s^v", v.Col)
 }
@@ -75,3 +135,52 @@ func (fc FunCall) String() string {
 	}
 	return fmt.Sprintf("%s(%s)", fc.Name, strings.Join(params, ", "))
 }
+
+func (v LabelColumnRef) String() string {
+	switch {
+	case v.Whole:
+		return fmt.Sprintf("@%s[*]", v.Label)
+	case v.Slice:
+		return fmt.Sprintf("@%s[%d:%d]", v.Label, v.From, v.To)
+	default:
+		return fmt.Sprintf("@%s[%d]", v.Label, v.From)
+	}
+}
+
+func (v SpillRef) String() string {
+	return fmt.Sprintf("%v#", v.Anchor)
+}
+
+func (v LocalName) String() string {
+	return v.Name
+}
+
+func (v ArrayLit) String() string {
+	rows := make([]string, len(v.Rows))
+	for i, row := range v.Rows {
+		elems := make([]string, len(row))
+		for j, expr := range row {
+			elems[j] = fmt.Sprint(expr)
+		}
+		rows[i] = strings.Join(elems, ", ")
+	}
+	return fmt.Sprintf("{%s}", strings.Join(rows, "; "))
+}
+
+func (v Let) String() string {
+	params := make([]string, 0, 2*len(v.Names)+1)
+	for i, name := range v.Names {
+		params = append(params, name, fmt.Sprint(v.Values[i]))
+	}
+	params = append(params, fmt.Sprint(v.Body))
+	return fmt.Sprintf("let(%s)", strings.Join(params, ", "))
+}
+
+func (v Lambda) String() string {
+	params := append(append([]string{}, v.Params...), fmt.Sprint(v.Body))
+	return fmt.Sprintf("lambda(%s)", strings.Join(params, ", "))
+}
+
+func (d FunctionDefCell) String() string {
+	return fmt.Sprintf("%s(%s) = %v", d.Name, strings.Join(d.Params, ", "), d.Body)
+}
@@ -0,0 +1,68 @@
+package model
+
+// ShiftRows returns expr with cell references moved by rows, like when a formula is copied
+// to another row; anchored rows ($A$1, A$1) are kept.
+func ShiftRows(expr Expr, rows int) Expr {
+	switch v := expr.(type) {
+	case CellRef:
+		if !v.RowAnchored {
+			v.Row += rows
+		}
+		return v
+	case CellRange:
+		return CellRange{
+			From: ShiftRows(v.From, rows).(CellRef),
+			To:   ShiftRows(v.To, rows).(CellRef),
+		}
+	case SpillRef:
+		return SpillRef{
+			Anchor: ShiftRows(v.Anchor, rows).(CellRef),
+		}
+	case SheetRef:
+		return SheetRef{
+			Sheet: v.Sheet,
+			Ref:   ShiftRows(v.Ref, rows),
+		}
+	case InfixOp:
+		return InfixOp{
+			Lhs: ShiftRows(v.Lhs, rows),
+			Rhs: ShiftRows(v.Rhs, rows),
+			Op:  v.Op,
+		}
+	case Negation:
+		return Negation{Expr: ShiftRows(v.Expr, rows)}
+	case FunCall:
+		return FunCall{
+			Name:   v.Name,
+			Params: shiftAll(v.Params, rows),
+		}
+	case ArrayLit:
+		res := ArrayLit{Rows: make([][]Expr, len(v.Rows))}
+		for i, row := range v.Rows {
+			res.Rows[i] = shiftAll(row, rows)
+		}
+		return res
+	case Let:
+		return Let{
+			Names:  v.Names,
+			Values: shiftAll(v.Values, rows),
+			Body:   ShiftRows(v.Body, rows),
+		}
+	case Lambda:
+		return Lambda{
+			Params: v.Params,
+			Body:   ShiftRows(v.Body, rows),
+		}
+	default:
+		// literals, names and references relative to labels or the current cell
+		return expr
+	}
+}
+
+func shiftAll(exprs []Expr, rows int) []Expr {
+	res := make([]Expr, len(exprs))
+	for i, expr := range exprs {
+		res[i] = ShiftRows(expr, rows)
+	}
+	return res
+}
@@ -0,0 +1,33 @@
+package model
+
+// Walk calls visit for expr and all its subexpressions, depth first.
+// Subexpressions of an expression are skipped when visit returns false.
+func Walk(expr Expr, visit func(Expr) bool) {
+	if !visit(expr) {
+		return
+	}
+	switch v := expr.(type) {
+	case InfixOp:
+		Walk(v.Lhs, visit)
+		Walk(v.Rhs, visit)
+	case Negation:
+		Walk(v.Expr, visit)
+	case FunCall:
+		for _, param := range v.Params {
+			Walk(param, visit)
+		}
+	case ArrayLit:
+		for _, row := range v.Rows {
+			for _, elem := range row {
+				Walk(elem, visit)
+			}
+		}
+	case Let:
+		for _, value := range v.Values {
+			Walk(value, visit)
+		}
+		Walk(v.Body, visit)
+	case Lambda:
+		Walk(v.Body, visit)
+	}
+}
@@ -0,0 +1,188 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Notation of cell references in formulas
+type Notation int
+
+const (
+	A1Notation Notation = iota
+	R1C1Notation
+)
+
+// Target returns 0-based row and column indices of the referenced cell, for a formula
+// in the cell at rowIdx, colIdx
+func (v R1C1Ref) Target(rowIdx, colIdx int) (int, int) {
+	targetRowIdx, targetColIdx := v.Row-1, v.Col-1
+	if v.RowRelative {
+		targetRowIdx = rowIdx + v.Row
+	}
+	if v.ColRelative {
+		targetColIdx = colIdx + v.Col
+	}
+	return targetRowIdx, targetColIdx
+}
+
+// columns are named with a single letter
+func colName(colIdx int) (string, bool) {
+	if colIdx < 0 || colIdx > 'Z'-'A' {
+		return "", false
+	}
+	return string(rune('A' + colIdx)), true
+}
+
+// A1 reference to the same cell; absolute parts of the reference become anchored,
+// false when the cell can't be named in A1 notation
+func (v R1C1Ref) ToA1(rowIdx, colIdx int) (CellRef, bool) {
+	targetRowIdx, targetColIdx := v.Target(rowIdx, colIdx)
+	col, ok := colName(targetColIdx)
+	if !ok || targetRowIdx < 0 {
+		return CellRef{}, false
+	}
+	return CellRef{
+		Col:         col,
+		Row:         targetRowIdx + 1,
+		ColAnchored: !v.ColRelative,
+		RowAnchored: !v.RowRelative,
+	}, true
+}
+
+// R1C1 reference to the same cell, anchored parts are absolute, the others relative
+// to the cell at rowIdx, colIdx
+func (cellRef CellRef) ToR1C1(rowIdx, colIdx int) R1C1Ref {
+	res := R1C1Ref{
+		Row: cellRef.Row,
+		Col: int(cellRef.Col[0]-'A') + 1,
+	}
+	if !cellRef.RowAnchored {
+		res.Row, res.RowRelative = res.Row-1-rowIdx, true
+	}
+	if !cellRef.ColAnchored {
+		res.Col, res.ColRelative = res.Col-1-colIdx, true
+	}
+	return res
+}
+
+// ToNotation returns expr of the formula in the cell at rowIdx, colIdx with cell references
+// written in the given notation; spill references like A2# are always in A1 notation and
+// references that A1 can't express stay in R1C1
+func ToNotation(expr Expr, notation Notation, rowIdx, colIdx int) Expr {
+	switch v := expr.(type) {
+	case CellRef:
+		if notation == R1C1Notation {
+			return v.ToR1C1(rowIdx, colIdx)
+		}
+		return v
+	case R1C1Ref:
+		if notation == A1Notation {
+			if ref, ok := v.ToA1(rowIdx, colIdx); ok {
+				return ref
+			}
+		}
+		return v
+	case CellRange:
+		if notation == R1C1Notation {
+			return R1C1Range{
+				From: v.From.ToR1C1(rowIdx, colIdx),
+				To:   v.To.ToR1C1(rowIdx, colIdx),
+			}
+		}
+		return v
+	case R1C1Range:
+		if notation == A1Notation {
+			from, fromOk := v.From.ToA1(rowIdx, colIdx)
+			to, toOk := v.To.ToA1(rowIdx, colIdx)
+			if fromOk && toOk {
+				return CellRange{From: from, To: to}
+			}
+		}
+		return v
+	case SheetRef:
+		return SheetRef{
+			Sheet: v.Sheet,
+			Ref:   ToNotation(v.Ref, notation, rowIdx, colIdx),
+		}
+	case InfixOp:
+		return InfixOp{
+			Lhs: ToNotation(v.Lhs, notation, rowIdx, colIdx),
+			Rhs: ToNotation(v.Rhs, notation, rowIdx, colIdx),
+			Op:  v.Op,
+		}
+	case Negation:
+		return Negation{Expr: ToNotation(v.Expr, notation, rowIdx, colIdx)}
+	case FunCall:
+		return FunCall{
+			Name:   v.Name,
+			Params: toNotationAll(v.Params, notation, rowIdx, colIdx),
+		}
+	case ArrayLit:
+		res := ArrayLit{Rows: make([][]Expr, len(v.Rows))}
+		for i, row := range v.Rows {
+			res.Rows[i] = toNotationAll(row, notation, rowIdx, colIdx)
+		}
+		return res
+	case Let:
+		return Let{
+			Names:  v.Names,
+			Values: toNotationAll(v.Values, notation, rowIdx, colIdx),
+			Body:   ToNotation(v.Body, notation, rowIdx, colIdx),
+		}
+	case Lambda:
+		return Lambda{
+			Params: v.Params,
+			Body:   ToNotation(v.Body, notation, rowIdx, colIdx),
+		}
+	default:
+		return expr
+	}
+}
+
+func toNotationAll(exprs []Expr, notation Notation, rowIdx, colIdx int) []Expr {
+	res := make([]Expr, len(exprs))
+	for i, expr := range exprs {
+		res[i] = ToNotation(expr, notation, rowIdx, colIdx)
+	}
+	return res
+}
+
+// FormatCell returns the input text of a cell, with its formula in the given notation.
+// Bodies of function definitions are evaluated where the function is called, so they are kept as written.
+func FormatCell(cell Cell, notation Notation, rowIdx, colIdx int) string {
+	switch v := cell.(type) {
+	case IntCell:
+		return strconv.Itoa(v.Value)
+	case FloatCell:
+		s := strconv.FormatFloat(v.Value, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			// keep it a float when parsed again
+			s += ".0"
+		}
+		return s
+	case StringCell:
+		return v.Value
+	case LabelCell:
+		return "!" + v.Label
+	case FormulaCell:
+		return fmt.Sprintf("=%v", ToNotation(v.Formula, notation, rowIdx, colIdx))
+	case FunctionDefCell:
+		return v.String()
+	default:
+		return ""
+	}
+}
+
+// FormatSheet returns input text of all cells, with formulas in the given notation
+func FormatSheet(cells [][]Cell, notation Notation) [][]string {
+	res := make([][]string, len(cells))
+	for rowIdx, row := range cells {
+		res[rowIdx] = make([]string, len(row))
+		for colIdx, cell := range row {
+			res[rowIdx][colIdx] = FormatCell(cell, notation, rowIdx, colIdx)
+		}
+	}
+	return res
+}
@@ -0,0 +1,40 @@
+package model
+
+import "strconv"
+
+// Scenario is a named set of parameters a sheet is evaluated with
+type Scenario struct {
+	Name   string
+	Params map[string]Cell
+}
+
+// ParamRange is the values a parameter takes across scenarios
+type ParamRange struct {
+	Name   string
+	Values []Cell
+}
+
+// Grid returns scenarios for all combinations of parameter values, numbered from 1; values of the
+// last parameter change fastest
+func Grid(ranges []ParamRange) []Scenario {
+	combinations := []map[string]Cell{{}}
+	for _, r := range ranges {
+		next := make([]map[string]Cell, 0, len(combinations)*len(r.Values))
+		for _, params := range combinations {
+			for _, value := range r.Values {
+				extended := make(map[string]Cell, len(params)+1)
+				for name, v := range params {
+					extended[name] = v
+				}
+				extended[r.Name] = value
+				next = append(next, extended)
+			}
+		}
+		combinations = next
+	}
+	res := make([]Scenario, len(combinations))
+	for i, params := range combinations {
+		res[i] = Scenario{Name: strconv.Itoa(i + 1), Params: params}
+	}
+	return res
+}
@@ -0,0 +1,13 @@
+package model
+
+// Workbook is a set of named sheets evaluated together, formulas of one sheet refer to cells of
+// another with Sheet!A1 or Sheet!@label<1>
+type Workbook struct {
+	Sheets []Sheet
+}
+
+type Sheet struct {
+	Name     string
+	Cells    [][]Cell
+	Included bool // pulled in by an !include row, for formulas to refer to
+}
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"strings"
 
 	p "github.com/a-h/parse"
@@ -64,6 +65,82 @@ var formulaCellParser = Map(
 	},
 )
 
+// =^^* only makes sense as the whole formula, =^^*2 is an error rather than ^^ times 2,
+// which can be written as =^^ * 2
+var fillDownCellParser = FallibleMap(
+	p.SequenceOf3[string, string, string](
+		p.Rune('='),
+		p.String("^^*"),
+		stringUntilEOFParser,
+	),
+	func(seq p.Tuple3[string, string, string]) (m.Cell, error) {
+		if seq.C != "" {
+			return nil, fmt.Errorf("=^^* fills down and must be the whole formula, got =^^*%s", seq.C)
+		}
+		return m.FormulaCell{
+			Formula: m.FillDown{},
+		}, nil
+	},
+)
+
+var functionParamsParser = SeparatedList0[string, m.NoResult](localName, argSeparatorParser)
+
+// e.g. withFee(x) = x + x*@fee<1>
+var functionDefParser = Map(
+	p.SequenceOf8[string, string, []string, string, m.NoResult, string, m.Expr, m.NoResult](
+		funNameParser,
+		lParen,
+		functionParamsParser,
+		rParen,
+		chompWhiteSpace,
+		p.Rune('='),
+		exprParser,
+		p.EOF[m.NoResult](),
+	),
+	func(seq p.Tuple8[string, string, []string, string, m.NoResult, string, m.Expr, m.NoResult]) m.Cell {
+		return m.FunctionDefCell{
+			Name:   seq.A,
+			Params: seq.C,
+			Body:   seq.G,
+		}
+	},
+)
+
+// label marking a row of function definitions
+const functionDefLabel = "def"
+
+func isFunctionDefRow(row []m.Cell) bool {
+	if len(row) == 0 {
+		return false
+	}
+	label, ok := row[0].(m.LabelCell)
+	return ok && label.Label == functionDefLabel
+}
+
+// turns text cells of !def rows into function definitions
+func parseFunctionDefs(rows [][]m.Cell) error {
+	for rowIdx, row := range rows {
+		if !isFunctionDefRow(row) {
+			continue
+		}
+		for colIdx, cell := range row[1:] {
+			text, ok := cell.(m.StringCell)
+			if !ok || text.Value == "" {
+				continue
+			}
+			def, ok, err := functionDefParser.Parse(p.NewInput(text.Value))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("invalid function definition in row %d, column %d: %s", rowIdx+1, colIdx+2, text.Value)
+			}
+			row[colIdx+1] = def
+		}
+	}
+	return nil
+}
+
 var stringUntilEOFParser = p.StringUntilEOF[m.NoResult](
 	p.Func[m.NoResult](func(in *p.Input) (m.NoResult, bool, error) {
 		return m.NoResult{}, false, nil
@@ -85,6 +162,7 @@ var cellParser = FallibleMap(
 		s = strings.TrimSpace(s)
 		match, ok, err := p.Any[m.Cell](
 			labelCellParser,
+			fillDownCellParser,
 			formulaCellParser,
 			floatCellParser,
 			intCellParser,
@@ -111,5 +189,9 @@ var csvParser p.Parser[[][]m.Cell] = p.Until(rowParser, p.EOF[string]())
 func ParseCSV(csvData string) ([][]m.Cell, bool, error) {
 	input := p.NewInput(csvData)
 
-	return csvParser.Parse(input)
+	rows, ok, err := csvParser.Parse(input)
+	if err != nil || !ok {
+		return rows, ok, err
+	}
+	return rows, ok, parseFunctionDefs(rows)
 }
@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	m "pasza.org/sr-challenge/model"
+)
+
+func TestParseCSVFunctionDefs(t *testing.T) {
+	rows, ok, err := ParseCSV("!def|withFee(x) = x + x*@fee<1>|two() = 2|\n=withFee(10)|withFee(x) = x\n")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	def, ok := rows[0][1].(m.FunctionDefCell)
+	assert.True(t, ok)
+	assert.Equal(t, "withFee", def.Name)
+	assert.Equal(t, []string{"x"}, def.Params)
+	assert.Equal(t, "x + (x * @fee<1>)", fmt.Sprint(def.Body))
+
+	def, ok = rows[0][2].(m.FunctionDefCell)
+	assert.True(t, ok)
+	assert.Empty(t, def.Params)
+
+	// definitions are only recognized in !def rows
+	assert.Equal(t, m.StringCell{Value: "withFee(x) = x"}, rows[1][1])
+}
+
+func TestParseCSVInvalidFunctionDef(t *testing.T) {
+	_, _, err := ParseCSV("!def|withFee(x) = x +\n")
+	assert.NotNil(t, err)
+}
+
+func TestFormatSheetNotation(t *testing.T) {
+	rows, ok, err := ParseCSV("!amount|1.0|text\n=A1 + $B$1 * C$3|=sum(A1:B2)|=RC[-1] + R1C1|=A2#\n")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	r1c1 := m.FormatSheet(rows, m.R1C1Notation)
+	assert.Equal(t, [][]string{
+		{"!amount", "1.0", "text"},
+		{"=R[-1]C + (R1C2 * R3C[2])", "=sum(R[-1]C[-1]:RC)", "=RC[-1] + R1C1", "=A2#"},
+	}, r1c1)
+
+	// formulas printed in R1C1 parse back to the same A1 formulas
+	text := ""
+	for _, row := range r1c1 {
+		text += strings.Join(row, "|") + "\n"
+	}
+	reparsed, ok, err := ParseCSV(text)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"=A1 + ($B$1 * C$3)", "=sum(A1:B2)", "=B2 + $A$1", "=A2#"},
+		m.FormatSheet(reparsed, m.A1Notation)[1])
+}
+
+func TestParseCSVFillDown(t *testing.T) {
+	rows, ok, err := ParseCSV("=^^*|=^^ *2| =^^* \n")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, m.FormulaCell{Formula: m.FillDown{}}, rows[0][0])
+	assert.Equal(t, "^^ * 2", fmt.Sprint(rows[0][1].(m.FormulaCell).Formula))
+	assert.Equal(t, m.FormulaCell{Formula: m.FillDown{}}, rows[0][2])
+
+	_, _, err = ParseCSV("1\n=^^*2\n")
+	assert.EqualError(t, err, "=^^* fills down and must be the whole formula, got =^^*2")
+}
+
+func TestParseWorkbook(t *testing.T) {
+	wb, ok, err := ParseWorkbook("1|2\n!sheet|Prices\n!eth\n2500\n!sheet|Summary\n=Prices!@eth<1> * A1\n", "main")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(wb.Sheets))
+	assert.Equal(t, []string{"main", "Prices", "Summary"}, []string{wb.Sheets[0].Name, wb.Sheets[1].Name, wb.Sheets[2].Name})
+	assert.Equal(t, [][]m.Cell{{m.LabelCell{Label: "eth"}}, {m.IntCell{Value: 2500}}}, wb.Sheets[1].Cells)
+
+	// without leading rows there's no sheet named after the file
+	wb, _, err = ParseWorkbook("!sheet|Prices\n1\n", "main")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(wb.Sheets))
+	assert.Equal(t, "Prices", wb.Sheets[0].Name)
+
+	for _, in := range []string{"!sheet\n1\n", "!sheet|two words\n", "!sheet|A\n!sheet|A\n"} {
+		_, _, err := ParseWorkbook(in, "main")
+		assert.NotNil(t, err, in)
+	}
+}
+
+func TestParseParam(t *testing.T) {
+	cases := []struct {
+		in        string
+		wantName  string
+		wantValue m.Cell
+	}{
+		{"fee=0.09", "fee", m.FloatCell{Value: 0.09}},
+		{"cost_threshold = 10000", "cost_threshold", m.IntCell{Value: 10000}},
+		{"token=eth", "token", m.StringCell{Value: "eth"}},
+		{"empty=", "empty", m.StringCell{Value: ""}},
+	}
+	for _, c := range cases {
+		name, value, err := ParseParam(c.in)
+		assert.Nil(t, err)
+		assert.Equal(t, c.wantName, name)
+		assert.Equal(t, c.wantValue, value)
+	}
+
+	for _, in := range []string{"fee", "1fee=2", "a b=1"} {
+		_, _, err := ParseParam(in)
+		assert.NotNil(t, err, in)
+	}
+}
+
+func TestParseParamRange(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []m.Cell
+	}{
+		{"fee=0.09", []m.Cell{m.FloatCell{Value: 0.09}}},
+		{"n=1:7:3", []m.Cell{m.IntCell{Value: 1}, m.IntCell{Value: 4}, m.IntCell{Value: 7}}},
+		{"fee=0.1:0.3:0.1", []m.Cell{m.FloatCell{Value: 0.1}, m.FloatCell{Value: 0.2}, m.FloatCell{Value: 0.3}}},
+		{"x=1:2:0.5", []m.Cell{m.FloatCell{Value: 1}, m.FloatCell{Value: 1.5}, m.FloatCell{Value: 2}}},
+	}
+	for _, c := range cases {
+		r, err := ParseParamRange(c.in)
+		assert.Nil(t, err, c.in)
+		assert.Equal(t, c.want, r.Values, c.in)
+	}
+
+	for _, in := range []string{"n=1:2", "n=1:a:1", "n=3:1:1", "n=1:2:0", "n=0:100000:1"} {
+		_, err := ParseParamRange(in)
+		assert.NotNil(t, err, in)
+	}
+}
+
+func TestParseScenarios(t *testing.T) {
+	scenarios, err := ParseScenarios("!scenario|!fee|!limit\nlow|0.01|5\n\nhigh|0.5\n")
+	assert.Nil(t, err)
+	assert.Equal(t, []m.Scenario{
+		{Name: "low", Params: map[string]m.Cell{"fee": m.FloatCell{Value: 0.01}, "limit": m.IntCell{Value: 5}}},
+		{Name: "high", Params: map[string]m.Cell{"fee": m.FloatCell{Value: 0.5}}},
+	}, scenarios)
+
+	scenarios, err = ParseScenarios("!fee\n1\n2\n")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2"}, []string{scenarios[0].Name, scenarios[1].Name})
+
+	for _, in := range []string{"fee\n1\n", "!fee\n1|2\n", "!fee\n=1+1\n", "!two words\n1\n"} {
+		_, err := ParseScenarios(in)
+		assert.NotNil(t, err, in)
+	}
+}
@@ -34,6 +34,17 @@ var intParser = FallibleMap(
 	},
 )
 
+// optional minus followed by digits
+var signedIntParser = Map(
+	p.SequenceOf2[p.Match[string], int](p.Optional(p.Rune('-')), intParser),
+	func(seq p.Tuple2[p.Match[string], int]) int {
+		if seq.A.OK {
+			return -seq.B
+		}
+		return seq.B
+	},
+)
+
 var intLitParser = Map(
 	intParser,
 	func(value int) m.Expr {
@@ -67,12 +78,85 @@ var floatLitParser = Map(
 	},
 )
 
+var anchorParser = p.Optional(p.Rune('$'))
+
+// A1, $A$1, $A1 or A$1
 var cellRefParser = Map(
-	p.SequenceOf2[string, int](colRefParser, intParser),
-	func(seq p.Tuple2[string, int]) m.Expr {
+	p.SequenceOf4[p.Match[string], string, p.Match[string], int](anchorParser, colRefParser, anchorParser, intParser),
+	func(seq p.Tuple4[p.Match[string], string, p.Match[string], int]) m.Expr {
 		return m.CellRef{
-			Col: seq.A,
-			Row: seq.B,
+			Col:         seq.B,
+			Row:         seq.D,
+			ColAnchored: seq.A.OK,
+			RowAnchored: seq.C.OK,
+		}
+	},
+)
+
+var spillRefParser = Map(
+	p.SequenceOf2[m.Expr, string](cellRefParser, p.Rune('#')),
+	func(seq p.Tuple2[m.Expr, string]) m.Expr {
+		return m.SpillRef{
+			Anchor: seq.A.(m.CellRef),
+		}
+	},
+)
+
+var cellRangeParser = Map(
+	p.SequenceOf3[m.Expr, string, m.Expr](cellRefParser, p.Rune(':'), cellRefParser),
+	func(seq p.Tuple3[m.Expr, string, m.Expr]) m.Expr {
+		return m.CellRange{
+			From: seq.A.(m.CellRef),
+			To:   seq.C.(m.CellRef),
+		}
+	},
+)
+
+type r1c1Part struct {
+	n        int
+	relative bool
+}
+
+// [-1] relative to the formula cell or 3 as absolute position, nothing for the same row or column
+var r1c1PartParser = Map(
+	p.Optional(p.Any[r1c1Part](
+		Map(
+			p.SequenceOf3[string, int, string](p.Rune('['), signedIntParser, p.Rune(']')),
+			func(seq p.Tuple3[string, int, string]) r1c1Part {
+				return r1c1Part{n: seq.B, relative: true}
+			},
+		),
+		Map(intParser, func(n int) r1c1Part {
+			return r1c1Part{n: n}
+		}),
+	)),
+	func(part p.Match[r1c1Part]) r1c1Part {
+		if !part.OK {
+			return r1c1Part{relative: true}
+		}
+		return part.Value
+	},
+)
+
+// R3C4, R[-1]C[2] or RC[-1]
+var r1c1RefParser = Map(
+	p.SequenceOf4[string, r1c1Part, string, r1c1Part](p.Rune('R'), r1c1PartParser, p.Rune('C'), r1c1PartParser),
+	func(seq p.Tuple4[string, r1c1Part, string, r1c1Part]) m.Expr {
+		return m.R1C1Ref{
+			Row:         seq.B.n,
+			Col:         seq.D.n,
+			RowRelative: seq.B.relative,
+			ColRelative: seq.D.relative,
+		}
+	},
+)
+
+var r1c1RangeParser = Map(
+	p.SequenceOf3[m.Expr, string, m.Expr](r1c1RefParser, p.Rune(':'), r1c1RefParser),
+	func(seq p.Tuple3[m.Expr, string, m.Expr]) m.Expr {
+		return m.R1C1Range{
+			From: seq.A.(m.R1C1Ref),
+			To:   seq.C.(m.R1C1Ref),
 		}
 	},
 )
@@ -95,17 +179,18 @@ var copyLastInColumnParser = Map(
 	},
 )
 
+// letter or _, followed by letters, digits, _ or dots, e.g. token_prices or fees.rate
 var labelName = Map(
-	p.OneOrMore[string](p.Any[string](
-		p.RuneInRanges(unicode.Lower),
-		p.RuneIn("_")), // allowed non-letter characters
+	p.SequenceOf2[string, []string](
+		p.Any(p.RuneInRanges(unicode.Letter), p.RuneIn("_")),
+		p.ZeroOrMore(p.Any(p.RuneInRanges(unicode.Letter, unicode.Digit), p.RuneIn("_."))),
 	),
-	func(chars []string) string {
-		return strings.Join(chars, "")
+	func(seq p.Tuple2[string, []string]) string {
+		return seq.A + strings.Join(seq.B, "")
 	},
 )
 var relativeRowRefParser = Map(
-	p.SequenceOf3[string, int, string](p.Rune('<'), intParser, p.Rune('>')),
+	p.SequenceOf3[string, int, string](p.Rune('<'), signedIntParser, p.Rune('>')),
 	func(t p.Tuple3[string, int, string]) int {
 		return t.B
 	},
@@ -124,6 +209,102 @@ var labelRelativeRowRefParser = Map(
 	},
 )
 
+var labelColumnWholeParser = Map(
+	p.Rune('*'),
+	func(string) m.LabelColumnRef {
+		return m.LabelColumnRef{Whole: true}
+	},
+)
+
+var labelColumnSliceParser = Map(
+	p.SequenceOf3[int, string, int](signedIntParser, p.Rune(':'), signedIntParser),
+	func(seq p.Tuple3[int, string, int]) m.LabelColumnRef {
+		return m.LabelColumnRef{From: seq.A, To: seq.C, Slice: true}
+	},
+)
+
+var labelColumnIndexParser = Map(
+	signedIntParser,
+	func(idx int) m.LabelColumnRef {
+		return m.LabelColumnRef{From: idx, To: idx}
+	},
+)
+
+// @label[*], @label[1:5] or @label[-1]
+var labelColumnRefParser = Map(
+	p.SequenceOf5[string, string, string, m.LabelColumnRef, string](
+		p.Rune('@'),
+		labelName,
+		p.Rune('['),
+		p.Any[m.LabelColumnRef](labelColumnWholeParser, labelColumnSliceParser, labelColumnIndexParser),
+		p.Rune(']'),
+	),
+	func(t p.Tuple5[string, string, string, m.LabelColumnRef, string]) m.Expr {
+		ref := t.D
+		ref.Label = t.B
+		return ref
+	},
+)
+
+// +1 or -1 after the @ of @label[@-1]
+var currentRowOffsetParser = Map(
+	p.SequenceOf2[string, int](p.RuneIn("+-"), intParser),
+	func(seq p.Tuple2[string, int]) int {
+		if seq.A == "-" {
+			return -seq.B
+		}
+		return seq.B
+	},
+)
+
+// @label[@] is the label column on the current row, @label[@-1] on the row above
+var labelCurrentRowRefParser = Map(
+	p.SequenceOf6[string, string, string, string, p.Match[int], string](
+		p.Rune('@'),
+		labelName,
+		p.Rune('['),
+		p.Rune('@'),
+		p.Optional(currentRowOffsetParser),
+		p.Rune(']'),
+	),
+	func(t p.Tuple6[string, string, string, string, p.Match[int], string]) m.Expr {
+		return m.LabelRelativeRowRef{
+			Label:          t.B,
+			RelativeRow:    t.E.Value,
+			FromCurrentRow: true,
+		}
+	},
+)
+
+var labelBlockParser = Map(
+	p.SequenceOf2[string, string](p.Rune('@'), labelName),
+	func(t p.Tuple2[string, string]) m.Expr {
+		return m.LabelBlock{
+			Label: t.B,
+		}
+	},
+)
+
+// lower case letter or _, followed by letters, digits or _
+var localName = Map(
+	p.SequenceOf2[string, []string](
+		p.Any(p.RuneInRanges(unicode.Lower), p.RuneIn("_")),
+		p.ZeroOrMore(p.Any(p.RuneInRanges(unicode.Letter, unicode.Digit), p.RuneIn("_"))),
+	),
+	func(seq p.Tuple2[string, []string]) string {
+		return seq.A + strings.Join(seq.B, "")
+	},
+)
+
+var localNameParser = Map(
+	localName,
+	func(name string) m.Expr {
+		return m.LocalName{
+			Name: name,
+		}
+	},
+)
+
 var chompWhiteSpace p.Parser[m.NoResult] = Map(
 	p.ZeroOrMore(p.RuneIn(" \t")),
 	func([]string) m.NoResult {
@@ -131,6 +312,37 @@ var chompWhiteSpace p.Parser[m.NoResult] = Map(
 	},
 )
 
+var arrayRowSeparatorParser = Map(
+	p.SequenceOf3[m.NoResult, string, m.NoResult](
+		chompWhiteSpace,
+		p.Rune(';'),
+		chompWhiteSpace,
+	),
+	func(_ p.Tuple3[m.NoResult, string, m.NoResult]) m.NoResult {
+		return m.NoResult{}
+	},
+)
+
+// {1, 2, 3} or {1, 2; 3, 4}
+var arrayLitParser = Map(
+	p.SequenceOf5[string, m.NoResult, [][]m.Expr, m.NoResult, string](
+		p.Rune('{'),
+		chompWhiteSpace,
+		SeparatedList1[[]m.Expr, m.NoResult](argListParser, arrayRowSeparatorParser),
+		chompWhiteSpace,
+		p.Rune('}'),
+	),
+	func(seq p.Tuple5[string, m.NoResult, [][]m.Expr, m.NoResult, string]) m.Expr {
+		rows := make([][]m.Expr, len(seq.C))
+		for i, row := range seq.C {
+			rows[i] = append(make([]m.Expr, 0, len(row)), row...)
+		}
+		return m.ArrayLit{
+			Rows: rows,
+		}
+	},
+)
+
 var subExprParser p.Parser[m.Expr] = Map(
 	p.SequenceOf3[string, m.Expr, string](
 		lParen,
@@ -142,18 +354,107 @@ var subExprParser p.Parser[m.Expr] = Map(
 	},
 )
 
+// -x, a minus before a number literal makes a negative literal
+var negationParser = Map(
+	p.SequenceOf2[string, m.Expr](
+		p.Rune('-'),
+		p.Func(func(in *p.Input) (m.Expr, bool, error) {
+			return primaryParserProxy.Parse(in)
+		}),
+	),
+	func(seq p.Tuple2[string, m.Expr]) m.Expr {
+		switch v := seq.B.(type) {
+		case m.IntLit:
+			return -v
+		case m.FloatLit:
+			return -v
+		default:
+			return m.Negation{Expr: v}
+		}
+	},
+)
+
+// name of a parameter given for the evaluation
+var paramName = Map(
+	p.SequenceOf2[string, []string](
+		p.Any(p.RuneInRanges(unicode.Letter), p.RuneIn("_")),
+		p.ZeroOrMore(p.Any(p.RuneInRanges(unicode.Letter, unicode.Digit), p.RuneIn("_"))),
+	),
+	func(seq p.Tuple2[string, []string]) string {
+		return seq.A + strings.Join(seq.B, "")
+	},
+)
+
+// $fee; $A1 is a cell reference, such parameters are only reachable with param("A1")
+var paramRefParser = Map(
+	p.SequenceOf2[string, string](p.Rune('$'), paramName),
+	func(seq p.Tuple2[string, string]) m.Expr {
+		return m.ParamRef{
+			Name: seq.B,
+		}
+	},
+)
+
+// name of a sheet in the workbook, e.g. Prices
+var sheetName = Map(
+	p.SequenceOf2[string, []string](
+		p.RuneInRanges(unicode.Letter),
+		p.ZeroOrMore(p.Any(p.RuneInRanges(unicode.Letter, unicode.Digit), p.RuneIn("_"))),
+	),
+	func(seq p.Tuple2[string, []string]) string {
+		return seq.A + strings.Join(seq.B, "")
+	},
+)
+
+// Prices!A1, Prices!A1:B3 or Prices!@fee<1>; references relative to the current row
+// like @fee[@] don't reach other sheets
+var sheetRefParser = Map(
+	p.SequenceOf3[string, string, m.Expr](
+		sheetName,
+		p.Rune('!'),
+		p.Any[m.Expr](
+			r1c1RangeParser,
+			r1c1RefParser,
+			cellRangeParser,
+			spillRefParser,
+			cellRefParser,
+			labelRelativeRowRefParser,
+			labelColumnRefParser,
+			labelBlockParser,
+		),
+	),
+	func(seq p.Tuple3[string, string, m.Expr]) m.Expr {
+		return m.SheetRef{
+			Sheet: seq.A,
+			Ref:   seq.C,
+		}
+	},
+)
+
 // temporary, eventually will include (expr), float, funCall, unaryOps
 var primaryParser p.Parser[m.Expr] = p.Any[m.Expr](
+	negationParser,
 	funCallParser,
 	stringLitParser,
 	floatLitParser,
 	intLitParser,
 	subExprParser,
+	arrayLitParser,
+	sheetRefParser,
+	r1c1RangeParser,
+	r1c1RefParser,
+	cellRangeParser,
+	spillRefParser,
 	cellRefParser,
+	paramRefParser,
 	copyAboveParser,
 	copyLastInColumnParser,
 	copyColumnAboveParser,
 	labelRelativeRowRefParser,
+	labelColumnRefParser,
+	labelCurrentRowRefParser,
+	labelBlockParser,
+	localNameParser,
 )
 
 var binaryOperatorParser = Map(
@@ -210,10 +511,14 @@ var exprParser p.Parser[m.Expr] = p.Func(func(in *p.Input) (match m.Expr, ok boo
 	return
 })
 
+// letter followed by letters or digits, e.g. log10
 var funNameParser = Map(
-	p.OneOrMore(p.RuneInRanges(unicode.Letter)),
-	func(chars []string) string {
-		return strings.Join(chars, "")
+	p.SequenceOf2[string, []string](
+		p.RuneInRanges(unicode.Letter),
+		p.ZeroOrMore(p.RuneInRanges(unicode.Letter, unicode.Digit)),
+	),
+	func(seq p.Tuple2[string, []string]) string {
+		return seq.A + strings.Join(seq.B, "")
 	},
 )
 
@@ -230,17 +535,76 @@ var argSeparatorParser = Map(
 
 var argListParser = SeparatedList0[m.Expr, m.NoResult](exprParser, argSeparatorParser)
 
-var funCallParser = Map(
+var funCallParser = FallibleMap(
 	p.SequenceOf4[string, string, []m.Expr, string](
 		funNameParser,
 		lParen,
 		argListParser,
 		rParen,
 	),
-	func(seq p.Tuple4[string, string, []m.Expr, string]) m.Expr {
+	func(seq p.Tuple4[string, string, []m.Expr, string]) (m.Expr, error) {
+		switch seq.A {
+		case "let":
+			return letFromArgs(seq.C)
+		case "lambda":
+			return lambdaFromArgs(seq.C)
+		}
 		return m.FunCall{
 			Name:   seq.A,
 			Params: seq.C,
-		}
+		}, nil
 	},
 )
+
+func nameArg(fname string, arg m.Expr) (string, error) {
+	name, ok := arg.(m.LocalName)
+	if !ok {
+		return "", fmt.Errorf("%s() expects a name, got %v", fname, arg)
+	}
+	return name.Name, nil
+}
+
+// let(name1, value1, ..., body)
+func letFromArgs(args []m.Expr) (m.Expr, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return nil, fmt.Errorf("let() expects name and value pairs followed by an expression")
+	}
+	res := m.Let{Body: args[len(args)-1]}
+	for i := 0; i < len(args)-1; i += 2 {
+		name, err := nameArg("let", args[i])
+		if err != nil {
+			return nil, err
+		}
+		res.Names = append(res.Names, name)
+		res.Values = append(res.Values, args[i+1])
+	}
+	return res, nil
+}
+
+// lambda(param1, ..., body)
+func lambdaFromArgs(args []m.Expr) (m.Expr, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("lambda() expects parameter names followed by an expression")
+	}
+	res := m.Lambda{Params: make([]string, 0), Body: args[len(args)-1]}
+	for _, arg := range args[:len(args)-1] {
+		name, err := nameArg("lambda", arg)
+		if err != nil {
+			return nil, err
+		}
+		res.Params = append(res.Params, name)
+	}
+	return res, nil
+}
+
+// ParseExpr parses a formula without the leading =, e.g. @adjusted_cost<1> * 2
+func ParseExpr(text string) (m.Expr, error) {
+	match, ok, err := p.SequenceOf2[m.Expr, m.NoResult](exprParser, p.EOF[m.NoResult]()).Parse(p.NewInput(strings.TrimSpace(text)))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid formula: %s", text)
+	}
+	return match.A, nil
+}
@@ -15,8 +15,10 @@ func TestLabelNameParser(t *testing.T) {
 		in   string
 		want string
 	}{
-		{"label1234", "label"},
+		{"label1234", "label1234"},
 		{"token_price", "token_price"},
+		{"Fees.rate_2<1>", "Fees.rate_2"},
+		{"total cost", "total"},
 	}
 
 	for _, c := range cases {
@@ -76,6 +78,7 @@ func TestLabelRelativeRowRefParser(t *testing.T) {
 		wantRowNum    int
 	}{
 		{"@token_price<77>", "token_price", 77},
+		{"@fee<-1>", "fee", -1},
 	}
 
 	for _, c := range cases {
@@ -150,6 +153,28 @@ func TestExprParser(t *testing.T) {
 		{"1 + 2 * 3 + 4", "(1 + (2 * 3)) + 4"},
 		{"1 + 2 * 3 + 4 * 5 - 6", "((1 + (2 * 3)) + (4 * 5)) - 6"},
 		{`E^+sum(spread(split(D3, ",")))`, `E^ + sum(spread(split(D3, ",")))`},
+		{`sum(A1:B3) + 1`, `sum(A1:B3) + 1`},
+		{`vlookup("eth", @token_prices, 2)`, `vlookup("eth", @token_prices, 2)`},
+		{`@fee<1> * 2`, `@fee<1> * 2`},
+		{`-3 * -1.5 - -A1`, `(-3 * -1.500) - -A1`},
+		{`abs(-2) + -(A1 + 1)`, `abs(-2) + -(A1 + 1)`},
+		{`let(x, 2, y, x*3, x + y)`, `let(x, 2, y, x * 3, x + y)`},
+		{`map(A1:A3, lambda(x, x*2))`, `map(A1:A3, lambda(x, x * 2))`},
+		{`{1, 2,3} * 2`, `{1, 2, 3} * 2`},
+		{`{-1, 2}`, `{-1, 2}`},
+		{`sum(A2#) + A2`, `sum(A2#) + A2`},
+		{`$A$1 + A$1 * $A1`, `$A$1 + (A$1 * $A1)`},
+		{`sum($B2:B$3)`, `sum($B2:B$3)`},
+		{`sum(@total_cost[*])`, `sum(@total_cost[*])`},
+		{`@fee[1:5] * @fee[-1]`, `@fee[1:5] * @fee[-1]`},
+		{`@fee[@] + @fee[@-1] + @fee[@+2] + @fee<-2>`, `((@fee[@] + @fee[@-1]) + @fee[@+2]) + @fee<-2>`},
+		{`@fee[-3:-1]`, `@fee[-3:-1]`},
+		{`{ 1,"a" ; A1+1, {} }`, `{1, "a"; A1 + 1, {}}`},
+		{`Prices!A1 + sum(Prices!A1:B2) * prices_2!@fee<1>`, `Prices!A1 + (sum(Prices!A1:B2) * prices_2!@fee<1>)`},
+		{`sum(Tx!@amount[*]) + Tx!RC[-1] + Tx!@rates`, `(sum(Tx!@amount[*]) + Tx!RC[-1]) + Tx!@rates`},
+		{`$fee * $A$1 + $A1 * $Fee_2`, `($fee * $A$1) + ($A1 * $Fee_2)`},
+		{`R[-1]C[2] + RC[-1] * R3C4`, `R[-1]C[2] + (RC[-1] * R3C4)`},
+		{`sum(R1C:R[0]C[-1]) + R3 + RC`, `(sum(R1C:RC[-1]) + R3) + RC`},
 	}
 	for _, c := range cases {
 		input := p.NewInput(c.in)
@@ -173,6 +198,12 @@ func TestIntLitParser(t *testing.T) {
 	assert.True(t, ok)
 	assert.Nil(t, err)
 	assert.Equal(t, m.IntLit(1), match)
+
+	in = p.NewInput("-12")
+	match, ok, err = primaryParser.Parse(in)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, m.IntLit(-12), match)
 }
 
 func TestFunCallParser(t *testing.T) {
@@ -188,6 +219,12 @@ func TestFunCallParser(t *testing.T) {
 			3,
 			[]string{"1", "2 + 3", "4"},
 		},
+		{
+			"log10(100)",
+			"log10",
+			1,
+			[]string{"100"},
+		},
 	}
 
 	for _, c := range cases {
@@ -206,3 +243,19 @@ func TestFunCallParser(t *testing.T) {
 		}
 	}
 }
+
+func TestLetAndLambdaParser(t *testing.T) {
+	match, ok, err := exprParser.Parse(p.NewInput("let(x, 1, f, lambda(a, b, a*x), f(2, 3))"))
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	let := match.(m.Let)
+	assert.Equal(t, []string{"x", "f"}, let.Names)
+	assert.Equal(t, []string{"a", "b"}, let.Values[1].(m.Lambda).Params)
+	assert.Equal(t, m.FunCall{Name: "f", Params: []m.Expr{m.IntLit(2), m.IntLit(3)}}, let.Body)
+
+	invalid := []string{"let(x, 1)", "let(1, 2, 3)", "lambda()", `lambda("x", 1)`}
+	for _, in := range invalid {
+		_, _, err := exprParser.Parse(p.NewInput(in))
+		assert.NotNil(t, err, in)
+	}
+}
@@ -0,0 +1,165 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	p "github.com/a-h/parse"
+	m "pasza.org/sr-challenge/model"
+)
+
+// label of a row pulling sheets of another file into the workbook, e.g. !include|fees.psv
+const includeLabel = "include"
+
+// SheetNameOf is the name of the sheet of a file, e.g. fees for shared/fees.psv; characters
+// that can't be in a sheet reference are replaced with _, so my-fees.psv is my_fees
+func SheetNameOf(path string) string {
+	base := filepath.Base(path)
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+// file named by the row, if it's an !include row
+func includeDirective(row []m.Cell) (string, bool) {
+	if len(row) == 0 {
+		return "", false
+	}
+	label, ok := row[0].(m.LabelCell)
+	if !ok || label.Label != includeLabel {
+		return "", false
+	}
+	var text m.StringCell
+	if len(row) > 1 {
+		text, _ = row[1].(m.StringCell)
+	}
+	return text.Value, true
+}
+
+type includeLoader struct {
+	searchPaths []string
+	loaded      map[string]bool
+	sheetsOf    map[string][]int // indexes of the sheets of each loaded file
+	stack       []string         // files being loaded, for include cycles
+	workbook    m.Workbook
+}
+
+// included file, next to the including one or in one of the search paths
+func (l *includeLoader) resolve(name string, dir string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	for _, searchPath := range append([]string{dir}, l.searchPaths...) {
+		path := filepath.Join(searchPath, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("included file %s not found", name)
+}
+
+func (l *includeLoader) load(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for i, loading := range l.stack {
+		if loading == absPath {
+			cycle := append(append([]string{}, l.stack[i:]...), absPath)
+			for j := range cycle {
+				cycle[j] = filepath.Base(cycle[j])
+			}
+			return fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if l.loaded[absPath] {
+		// included before, but also asked for directly
+		if len(l.stack) == 0 {
+			for _, i := range l.sheetsOf[absPath] {
+				l.workbook.Sheets[i].Included = false
+			}
+		}
+		return nil
+	}
+	input, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	wb, ok, err := ParseWorkbook(string(input), SheetNameOf(path))
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if !ok {
+		return fmt.Errorf("%s: expected CSV not matched", path)
+	}
+	// like !sheet rows, !include rows are left out of the sheet, so they don't define a label
+	var includes []string
+	for i, sheet := range wb.Sheets {
+		wb.Sheets[i].Included = len(l.stack) > 0
+		cells := make([][]m.Cell, 0, len(sheet.Cells))
+		for _, row := range sheet.Cells {
+			name, isInclude := includeDirective(row)
+			if !isInclude {
+				cells = append(cells, row)
+				continue
+			}
+			if name == "" {
+				return fmt.Errorf("%s: missing file name in !%s row", path, includeLabel)
+			}
+			includes = append(includes, name)
+		}
+		wb.Sheets[i].Cells = cells
+	}
+	for i := range wb.Sheets {
+		l.sheetsOf[absPath] = append(l.sheetsOf[absPath], len(l.workbook.Sheets)+i)
+	}
+	l.workbook.Sheets = append(l.workbook.Sheets, wb.Sheets...)
+	l.stack = append(l.stack, absPath)
+	for _, name := range includes {
+		included, err := l.resolve(name, filepath.Dir(path))
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if err := l.load(included); err != nil {
+			return err
+		}
+	}
+	l.stack = l.stack[:len(l.stack)-1]
+	l.loaded[absPath] = true
+	return nil
+}
+
+// LoadWorkbook reads sheets of the files into one workbook, with sheets of files they include
+// with !include|fees.psv rows, which are left out of the sheets like !sheet rows. Included
+// files are looked up next to the including file, then in searchPaths; each is loaded once
+// and its labels are reached through its sheet, e.g. fees!@fee<1>.
+func LoadWorkbook(searchPaths []string, paths ...string) (m.Workbook, error) {
+	l := includeLoader{
+		searchPaths: searchPaths,
+		loaded:      make(map[string]bool),
+		sheetsOf:    make(map[string][]int),
+	}
+	for _, path := range paths {
+		if err := l.load(path); err != nil {
+			return l.workbook, err
+		}
+	}
+	seen := make(map[string]bool)
+	for _, sheet := range l.workbook.Sheets {
+		if seen[sheet.Name] {
+			return l.workbook, fmt.Errorf("sheet %s is defined twice", sheet.Name)
+		}
+		seen[sheet.Name] = true
+		// a single sheet isn't referenced by name, so any file name will do
+		if _, ok, _ := sheetNameCellParser.Parse(p.NewInput(sheet.Name)); !ok && len(l.workbook.Sheets) > 1 {
+			return l.workbook, fmt.Errorf("sheet name %s can't be referenced, it must start with a letter", sheet.Name)
+		}
+	}
+	return l.workbook, nil
+}
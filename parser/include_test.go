@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestLoadWorkbookIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"tx.psv":            "!include|rates.psv\n!include|fees.psv\n=fees!@fee<1> + rates!A1\n",
+		"rates.psv":         "1.5\n!include|fees.psv\n",
+		"shared/fees.psv":   "!fee\n0.5\n",
+		"loop/a.psv":        "!include|b.psv\n",
+		"loop/b.psv":        "!include|a.psv\n",
+		"missing.psv":       "!include|nope.psv\n",
+		"shared/broken.psv": "!include\n",
+		"dash.psv":          "!include|my-fees.psv\n=my_fees!A1\n",
+		"my-fees.psv":       "0.5\n",
+		"digit.psv":         "!include|2fees.psv\n",
+		"2fees.psv":         "0.5\n",
+	})
+
+	wb, err := LoadWorkbook([]string{filepath.Join(dir, "shared")}, filepath.Join(dir, "tx.psv"))
+	require.Nil(t, err)
+	names := make([]string, len(wb.Sheets))
+	for i, sheet := range wb.Sheets {
+		names[i] = sheet.Name
+	}
+	// fees.psv is included twice but loaded once
+	assert.Equal(t, []string{"tx", "rates", "fees"}, names)
+	// !include rows are left out of the sheets
+	assert.Len(t, wb.Sheets[0].Cells, 1)
+	assert.Len(t, wb.Sheets[1].Cells, 1)
+	assert.Equal(t, []bool{false, true, true}, []bool{wb.Sheets[0].Included, wb.Sheets[1].Included, wb.Sheets[2].Included})
+
+	// a file also loaded directly isn't just included
+	wb, err = LoadWorkbook([]string{filepath.Join(dir, "shared")}, filepath.Join(dir, "tx.psv"), filepath.Join(dir, "shared", "fees.psv"))
+	require.Nil(t, err)
+	assert.Equal(t, []bool{false, true, false}, []bool{wb.Sheets[0].Included, wb.Sheets[1].Included, wb.Sheets[2].Included})
+
+	// file names are made into names sheet references can use
+	wb, err = LoadWorkbook(nil, filepath.Join(dir, "dash.psv"))
+	require.Nil(t, err)
+	assert.Equal(t, "my_fees", wb.Sheets[1].Name)
+
+	_, err = LoadWorkbook(nil, filepath.Join(dir, "digit.psv"))
+	assert.EqualError(t, err, "sheet name 2fees can't be referenced, it must start with a letter")
+
+	_, err = LoadWorkbook(nil, filepath.Join(dir, "loop", "a.psv"))
+	assert.EqualError(t, err, "include cycle: a.psv -> b.psv -> a.psv")
+
+	_, err = LoadWorkbook(nil, filepath.Join(dir, "tx.psv"))
+	assert.ErrorContains(t, err, "included file fees.psv not found")
+
+	_, err = LoadWorkbook(nil, filepath.Join(dir, "missing.psv"))
+	assert.ErrorContains(t, err, "included file nope.psv not found")
+
+	_, err = LoadWorkbook(nil, filepath.Join(dir, "shared", "broken.psv"))
+	assert.ErrorContains(t, err, "missing file name in !include row")
+}
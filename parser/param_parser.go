@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	p "github.com/a-h/parse"
+	m "pasza.org/sr-challenge/model"
+)
+
+var paramNameParser = p.SequenceOf2[string, m.NoResult](paramName, p.EOF[m.NoResult]())
+
+// ParseValue reads a parameter value the way a cell literal is read: 5 is an int, 0.09 a float,
+// anything else a string
+func ParseValue(text string) m.Cell {
+	match, ok, err := p.Any[m.Cell](
+		floatCellParser,
+		intCellParser,
+		stringCellParser,
+	).Parse(p.NewInput(strings.TrimSpace(text)))
+	if err != nil || !ok {
+		return m.StringCell{Value: text}
+	}
+	return match
+}
+
+// ParseParam reads a name=value parameter definition, e.g. fee=0.09
+func ParseParam(def string) (string, m.Cell, error) {
+	name, value, found := strings.Cut(def, "=")
+	if !found {
+		return "", nil, fmt.Errorf("parameter %q must be given as name=value", def)
+	}
+	name = strings.TrimSpace(name)
+	if _, ok, err := paramNameParser.Parse(p.NewInput(name)); err != nil || !ok {
+		return "", nil, fmt.Errorf("invalid parameter name %q, use letters, digits and _", name)
+	}
+	return name, ParseValue(value), nil
+}
+
+// most values a parameter takes in a sweep
+const maxRangeValues = 10000
+
+func decimals(text string) int {
+	if _, fraction, found := strings.Cut(text, "."); found {
+		return len(fraction)
+	}
+	return 0
+}
+
+// numbers from, from+step, ... up to to, ints when all bounds are ints
+func rangeValues(fromText, toText, stepText string) ([]m.Cell, error) {
+	bounds := make([]float64, 3)
+	allInts := true
+	for i, text := range []string{fromText, toText, stepText} {
+		switch v := ParseValue(text).(type) {
+		case m.IntCell:
+			bounds[i] = float64(v.Value)
+		case m.FloatCell:
+			bounds[i], allInts = v.Value, false
+		default:
+			return nil, fmt.Errorf("range bound %q must be a number", strings.TrimSpace(text))
+		}
+	}
+	from, to, step := bounds[0], bounds[1], bounds[2]
+	if step <= 0 || to < from {
+		return nil, fmt.Errorf("range %s:%s:%s must go up from its start with a positive step", fromText, toText, stepText)
+	}
+	count := int((to-from)/step+1e-9) + 1
+	if count > maxRangeValues {
+		return nil, fmt.Errorf("range %s:%s:%s has more than %d values", fromText, toText, stepText, maxRangeValues)
+	}
+	// same precision as the bounds, without float drift
+	precision := 0
+	for _, text := range []string{fromText, toText, stepText} {
+		if d := decimals(strings.TrimSpace(text)); d > precision {
+			precision = d
+		}
+	}
+	values := make([]m.Cell, count)
+	for i := range values {
+		v := from + float64(i)*step
+		if allInts {
+			values[i] = m.IntCell{Value: int(v)}
+			continue
+		}
+		rounded, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'f', precision, 64), 64)
+		values[i] = m.FloatCell{Value: rounded}
+	}
+	return values, nil
+}
+
+// ParseParamRange reads values of a parameter in a sweep: fee=0.05:0.1:0.01 goes from 0.05 to 0.1
+// in steps of 0.01, fee=0.09 is a single value
+func ParseParamRange(def string) (m.ParamRange, error) {
+	name, value, err := ParseParam(def)
+	if err != nil {
+		return m.ParamRange{}, err
+	}
+	_, text, _ := strings.Cut(def, "=")
+	bounds := strings.Split(text, ":")
+	switch len(bounds) {
+	case 1:
+		return m.ParamRange{Name: name, Values: []m.Cell{value}}, nil
+	case 3:
+		values, err := rangeValues(bounds[0], bounds[1], bounds[2])
+		if err != nil {
+			return m.ParamRange{}, fmt.Errorf("parameter %s: %v", name, err)
+		}
+		return m.ParamRange{Name: name, Values: values}, nil
+	default:
+		return m.ParamRange{}, fmt.Errorf("parameter %s: range must be given as from:to:step", name)
+	}
+}
@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+
+	p "github.com/a-h/parse"
+	m "pasza.org/sr-challenge/model"
+)
+
+// label of the scenario file column naming scenarios
+const scenarioLabel = "scenario"
+
+// ParseScenarios reads scenarios from a sheet with a row of labels naming parameters, then a row of
+// values per scenario, e.g. !fee|!cost_threshold then 0.09|10000. A !scenario column names scenarios,
+// otherwise they are numbered from 1.
+func ParseScenarios(csvData string) ([]m.Scenario, error) {
+	rows, ok, err := ParseCSV(csvData)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || len(rows) == 0 {
+		return nil, fmt.Errorf("expected a row of parameter labels")
+	}
+	names := make([]string, len(rows[0]))
+	for colIdx, cell := range rows[0] {
+		label, ok := cell.(m.LabelCell)
+		if !ok {
+			return nil, fmt.Errorf("column %d: expected a parameter label, got %v", colIdx+1, cell)
+		}
+		if label.Label != scenarioLabel {
+			if _, ok, err := paramNameParser.Parse(p.NewInput(label.Label)); err != nil || !ok {
+				return nil, fmt.Errorf("invalid parameter name %q, use letters, digits and _", label.Label)
+			}
+		}
+		names[colIdx] = label.Label
+	}
+	scenarios := make([]m.Scenario, 0, len(rows)-1)
+	for rowIdx, row := range rows[1:] {
+		if isBlankRow(row) {
+			continue
+		}
+		if len(row) > len(names) {
+			return nil, fmt.Errorf("row %d: more values than parameters", rowIdx+2)
+		}
+		scenario := m.Scenario{Name: strconv.Itoa(len(scenarios) + 1), Params: make(map[string]m.Cell)}
+		for colIdx, cell := range row {
+			switch v := cell.(type) {
+			case m.IntCell, m.FloatCell, m.StringCell:
+				if names[colIdx] == scenarioLabel {
+					scenario.Name = m.FormatCell(v, m.A1Notation, 0, 0)
+				} else {
+					scenario.Params[names[colIdx]] = v
+				}
+			default:
+				return nil, fmt.Errorf("row %d: parameter %s must be a value", rowIdx+2, names[colIdx])
+			}
+		}
+		scenarios = append(scenarios, scenario)
+	}
+	return scenarios, nil
+}
+
+func isBlankRow(row []m.Cell) bool {
+	for _, cell := range row {
+		if s, ok := cell.(m.StringCell); !ok || s.Value != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"fmt"
+
+	p "github.com/a-h/parse"
+	m "pasza.org/sr-challenge/model"
+)
+
+// label of a row starting a new sheet, e.g. !sheet|Prices
+const sheetLabel = "sheet"
+
+var sheetNameCellParser = p.SequenceOf2[string, m.NoResult](sheetName, p.EOF[m.NoResult]())
+
+// name of the sheet started by the row, if it's a !sheet row
+func sheetDirective(row []m.Cell) (string, bool, error) {
+	if len(row) == 0 {
+		return "", false, nil
+	}
+	label, ok := row[0].(m.LabelCell)
+	if !ok || label.Label != sheetLabel {
+		return "", false, nil
+	}
+	var text m.StringCell
+	if len(row) > 1 {
+		text, _ = row[1].(m.StringCell)
+	}
+	match, ok, err := sheetNameCellParser.Parse(p.NewInput(text.Value))
+	if err != nil || !ok {
+		return "", true, fmt.Errorf("invalid sheet name %q in !%s row", text.Value, sheetLabel)
+	}
+	return match.A, true, nil
+}
+
+// ParseWorkbook parses sheets of a file split with !sheet|Name rows; rows before the first
+// of them make a sheet with the given name, left out when there are none
+func ParseWorkbook(csvData string, name string) (m.Workbook, bool, error) {
+	rows, ok, err := ParseCSV(csvData)
+	if err != nil || !ok {
+		return m.Workbook{}, ok, err
+	}
+	wb := m.Workbook{}
+	current := m.Sheet{Name: name, Cells: make([][]m.Cell, 0)}
+	started := false // current sheet started with a !sheet row
+	for _, row := range rows {
+		sheet, isDirective, err := sheetDirective(row)
+		if err != nil {
+			return wb, true, err
+		}
+		if !isDirective {
+			current.Cells = append(current.Cells, row)
+			continue
+		}
+		if started || len(current.Cells) > 0 {
+			wb.Sheets = append(wb.Sheets, current)
+		}
+		current, started = m.Sheet{Name: sheet, Cells: make([][]m.Cell, 0)}, true
+	}
+	wb.Sheets = append(wb.Sheets, current)
+	seen := make(map[string]bool)
+	for _, sheet := range wb.Sheets {
+		if seen[sheet.Name] {
+			return wb, true, fmt.Errorf("sheet %s is defined twice", sheet.Name)
+		}
+		seen[sheet.Name] = true
+	}
+	return wb, true, nil
+}
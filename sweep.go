@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"pasza.org/sr-challenge/evaluator"
+	"pasza.org/sr-challenge/model"
+	"pasza.org/sr-challenge/parser"
+)
+
+type sweepOptions struct {
+	ranges        []model.ParamRange
+	scenariosPath string
+	outputs       []model.Expr
+	seed          int64
+	seedSet       bool
+	includePaths  []string
+}
+
+func parseSweepOptions(args []string) (opts sweepOptions, flags *flag.FlagSet) {
+	flags = flag.NewFlagSet("sweep", flag.ExitOnError)
+	flags.Func("D", "parameter values name=from:to:step or name=value, scenarios are all combinations (repeatable)", func(s string) error {
+		r, err := parser.ParseParamRange(s)
+		if err != nil {
+			return err
+		}
+		opts.ranges = append(opts.ranges, r)
+		return nil
+	})
+	flags.StringVar(&opts.scenariosPath, "scenarios", "", "file with a row of parameter labels and a scenario per row, instead of -D")
+	flags.Func("out", "output to compare, a cell or formula like E4 or @adjusted_cost<1> (repeatable)", func(s string) error {
+		expr, err := parser.ParseExpr(s)
+		if err != nil {
+			return err
+		}
+		opts.outputs = append(opts.outputs, expr)
+		return nil
+	})
+	flags.Func("seed", "seed for rand() and randbetween(), the same for every scenario", func(s string) error {
+		_, err := fmt.Sscan(s, &opts.seed)
+		opts.seedSet = err == nil
+		return err
+	})
+	flags.Func("I", "directory to look for included files in (repeatable)", func(s string) error {
+		opts.includePaths = append(opts.includePaths, s)
+		return nil
+	})
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s sweep [options] <input_file> [output_file]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	return
+}
+
+func sweepScenarios(opts sweepOptions) []model.Scenario {
+	if opts.scenariosPath == "" {
+		return model.Grid(opts.ranges)
+	}
+	if len(opts.ranges) > 0 {
+		log.Fatal("use either -D or -scenarios\n")
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(opts.scenariosPath)
+	if err != nil {
+		log.Fatal(err)
+		os.Exit(1)
+	}
+	scenarios, err := parser.ParseScenarios(string(data))
+	if err != nil {
+		log.Fatalf("failed to parse scenarios: %v\n", err)
+		os.Exit(1)
+	}
+	return scenarios
+}
+
+// parameter names of all scenarios, in alphabetical order
+func paramNames(scenarios []model.Scenario) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, scenario := range scenarios {
+		for name := range scenario.Params {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// evaluates the sheet for each scenario and writes a table of the parameters and outputs
+func runSweep(args []string) {
+	opts, flags := parseSweepOptions(args)
+	inputPath, outputPath := validateCommandLine(flags.Args(), flags.Usage)
+	if len(opts.outputs) == 0 {
+		log.Fatal("no outputs to compare, add -out\n")
+		os.Exit(1)
+	}
+	scenarios := sweepScenarios(opts)
+	if len(scenarios) == 0 {
+		log.Fatal("no scenarios to evaluate\n")
+		os.Exit(1)
+	}
+	wb, err := parser.LoadWorkbook(opts.includePaths, inputPath)
+	if err != nil {
+		log.Fatalf("failed to load: %v\n", err)
+		os.Exit(1)
+	}
+	evalOptions := make([]evaluator.Option, 0)
+	if opts.seedSet {
+		evalOptions = append(evalOptions, evaluator.WithSeed(opts.seed))
+	}
+
+	// every scenario is checked, one may miss a parameter the others give
+	errorCount := 0
+	reported := make(map[string]bool)
+	report := func(scenario model.Scenario, problem string, isError bool) {
+		if reported[problem] {
+			return
+		}
+		reported[problem] = true
+		if len(scenarios) > 1 {
+			problem = fmt.Sprintf("scenario %s: %s", scenario.Name, problem)
+		}
+		log.Println(problem)
+		if isError {
+			errorCount++
+		}
+	}
+	for _, scenario := range scenarios {
+		checkOptions := append([]evaluator.Option{evaluator.WithParams(scenario.Params)}, evalOptions...)
+		for _, d := range evaluator.CheckWorkbook(wb, checkOptions...) {
+			report(scenario, d.String(), !d.Warning)
+		}
+		for _, problem := range evaluator.CheckOutputs(wb, opts.outputs, checkOptions...) {
+			report(scenario, problem, true)
+		}
+	}
+	if errorCount > 0 {
+		log.Fatalf("%d problem(s) found in formulas\n", errorCount)
+		os.Exit(1)
+	}
+
+	names := paramNames(scenarios)
+	for _, name := range evaluator.UnusedParams(wb, opts.outputs, names) {
+		log.Printf("warning: no formula or output refers to parameter $%s, it doesn't change the results\n", name)
+	}
+
+	header := []text{"!scenario"}
+	for _, name := range names {
+		header = append(header, text("!"+name))
+	}
+	for _, output := range opts.outputs {
+		header = append(header, text(fmt.Sprintf("!%v", output)))
+	}
+	table := [][]text{header}
+	for _, result := range evaluator.SweepWorkbook(wb, scenarios, opts.outputs, evalOptions...) {
+		row := []text{text(result.Scenario.Name)}
+		for _, name := range names {
+			value := ""
+			if cell, ok := result.Scenario.Params[name]; ok {
+				value = model.FormatCell(cell, model.A1Notation, 0, 0)
+			}
+			row = append(row, text(value))
+		}
+		for _, v := range result.Values {
+			row = append(row, text(v.String()))
+		}
+		table = append(table, row)
+	}
+	writeOutput(outputPath, table)
+}